@@ -0,0 +1,262 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache is a second-level cache of the rows fetched by Get, set on
+// DbMap.Cache.  Keys identify a row by its table and primary key.
+// Implementations must be safe for concurrent use.
+//
+// Get stores a copy of each row it fetches outside of a Transaction, and
+// returns copies of cached rows.  The values of slice, map and pointer
+// columns, such as []byte, are copied too, so a row returned by Get can
+// be modified freely.  Update, UpdateColumns, Upsert, Delete,
+// HardDelete and Restore remove the rows they write; inside a Transaction
+// they do so when it is committed, and Get inside a Transaction does not
+// use the cache at all.  Rows written with Exec or by other processes are
+// not removed, so a Cache suits tables that change rarely or through gorp
+// only.
+type Cache interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+	Delete(key string)
+}
+
+// LRUCache is a Cache holding up to a fixed number of rows, evicting the
+// least recently used row when full.  If its TTL is not zero, rows expire
+// that long after they are cached.
+type LRUCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// NewLRUCache returns an LRUCache holding up to size rows for at most
+// ttl, or without expiry if ttl is 0.
+func NewLRUCache(size int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get returns the value cached under key, unless it has expired.
+func (c *LRUCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return entry.value, true
+}
+
+// Set caches value under key, evicting the least recently used value if
+// the cache is full.
+func (c *LRUCache) Set(key string, value interface{}) {
+	if c.size <= 0 {
+		return
+	}
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value = &lruEntry{key: key, value: value, expires: expires}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete removes the value cached under key.
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+// Len returns the number of cached values, including expired ones that
+// have not been evicted yet.
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRUCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*lruEntry).key)
+}
+
+// cacheKey returns the Cache key of the row of t with the given primary
+// key values.
+func (t *TableMap) cacheKey(keys ...interface{}) string {
+	s := strings.Builder{}
+	if t.SchemaName != "" {
+		s.WriteString(t.SchemaName)
+		s.WriteString(".")
+	}
+	s.WriteString(t.TableName)
+	for _, k := range keys {
+		if v, ok := relationKey(reflect.ValueOf(k)); ok {
+			k = v
+		}
+		// quoted, so that the values of composite keys can't run together
+		s.WriteString("|")
+		s.WriteString(strconv.Quote(fmt.Sprint(k)))
+	}
+	return s.String()
+}
+
+// rowCacheKey returns the Cache key of the row elem.
+func (t *TableMap) rowCacheKey(elem reflect.Value) string {
+	return t.cacheKey(t.keyValues(elem)...)
+}
+
+// keyValues returns the primary key values of the row elem.
+func (t *TableMap) keyValues(elem reflect.Value) []interface{} {
+	keys := make([]interface{}, len(t.keys))
+	for i, col := range t.keys {
+		keys[i] = elem.FieldByName(col.fieldName).Interface()
+	}
+	return keys
+}
+
+// usesCache reports whether Get on t run by exec reads and fills the
+// Cache of m.
+func (m *DbMap) usesCache(t *TableMap, exec SqlExecutor) bool {
+	if m.Cache == nil {
+		return false
+	}
+	if _, ok := exec.(*Transaction); ok {
+		return false
+	}
+	// only rows that are not soft deleted are cached, and rows of tables
+	// scoped by tenant are not, as the cache is shared by all tenants
+	return t.tenant == nil && (t.softDelete == nil || t.filtered(exec))
+}
+
+// uncache removes the row elem of t from the Cache, once the Transaction
+// commits if exec is one.
+func (m *DbMap) uncache(exec SqlExecutor, t *TableMap, elem reflect.Value) {
+	if m.Cache == nil {
+		return
+	}
+	key := t.rowCacheKey(elem)
+	if tx, ok := exec.(*Transaction); ok {
+		*tx.uncached = append(*tx.uncached, key)
+		return
+	}
+	m.invalidate(key)
+}
+
+// cacheGenerations counts the invalidations of the Cache keys, by a hash
+// of the key, so that Get doesn't cache a row it read before the row was
+// written and its key invalidated.  Keys sharing a counter only cause
+// some rows to be read again.
+var cacheGenerations [256]uint64
+
+// cacheGeneration returns the invalidation counter of key.
+func cacheGeneration(key string) *uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &cacheGenerations[h.Sum32()%uint32(len(cacheGenerations))]
+}
+
+// invalidate removes key from the Cache of m.
+func (m *DbMap) invalidate(key string) {
+	atomic.AddUint64(cacheGeneration(key), 1)
+	m.Cache.Delete(key)
+}
+
+// cacheRow caches a copy of the row v of t, read by a Get that found gen
+// as the generation of key, unless key has been invalidated since.
+func (m *DbMap) cacheRow(t *TableMap, key string, gen uint64, v reflect.Value) {
+	counter := cacheGeneration(key)
+	if atomic.LoadUint64(counter) != gen {
+		return
+	}
+	m.Cache.Set(key, t.copyRow(v).Interface())
+	// an invalidation between the check and Set may have missed the row
+	if atomic.LoadUint64(counter) != gen {
+		m.Cache.Delete(key)
+	}
+}
+
+// copyRow returns a copy of the row v of t, in which the values of the
+// slice, map and pointer columns are copied as well.
+func (t *TableMap) copyRow(v reflect.Value) reflect.Value {
+	row := reflect.New(v.Type()).Elem()
+	row.Set(v)
+	for _, col := range t.Columns {
+		if col.Transient {
+			continue
+		}
+		f := row.FieldByName(col.fieldName)
+		if !f.IsValid() || !f.CanSet() {
+			continue
+		}
+		switch f.Kind() {
+		case reflect.Slice:
+			if !f.IsNil() {
+				c := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+				reflect.Copy(c, f)
+				f.Set(c)
+			}
+		case reflect.Map:
+			if !f.IsNil() {
+				c := reflect.MakeMapWithSize(f.Type(), f.Len())
+				for it := f.MapRange(); it.Next(); {
+					c.SetMapIndex(it.Key(), it.Value())
+				}
+				f.Set(c)
+			}
+		case reflect.Ptr:
+			if !f.IsNil() {
+				c := reflect.New(f.Type().Elem())
+				c.Elem().Set(f.Elem())
+				f.Set(c)
+			}
+		}
+	}
+	return row
+}
@@ -4,7 +4,10 @@
 
 package gorp
 
-import "reflect"
+import (
+	"reflect"
+	"strings"
+)
 
 // ColumnMap represents a mapping between a Go struct field and a single
 // column in a table.
@@ -30,11 +33,24 @@ type ColumnMap struct {
 
 	DefaultValue string
 
+	// If not nil, a foreign key constraint is added to create table
+	// statements for this column.
+	ForeignKey *ForeignKey
+
 	fieldName  string
 	gotype     reflect.Type
 	isPK       bool
 	isAutoIncr bool
 	isNotNull  bool
+
+	// isCreateTime and isUpdateTime mark the timestamp columns set by
+	// Insert and Update; see TableMap.SetTimestampCols.
+	isCreateTime bool
+	isUpdateTime bool
+
+	// sensitive masks the values bound for this column in the SQL log;
+	// see SetSensitive.
+	sensitive bool
 }
 
 // Rename allows you to specify the column name in the table
@@ -60,6 +76,18 @@ func (c *ColumnMap) SetUnique(b bool) *ColumnMap {
 	return c
 }
 
+// SetSensitive masks the values of this column in the statements logged
+// by TraceOn and passed to interceptors, if b is true.  Use it for
+// columns holding passwords, tokens and the like.  Only the arguments
+// gorp binds from struct fields are masked; wrap the arguments of
+// hand-written SQL with Sensitive.  Like the other column settings, it
+// must be made before the table is used, or be followed by a call to
+// TableMap.ResetSql.
+func (c *ColumnMap) SetSensitive(b bool) *ColumnMap {
+	c.sensitive = b
+	return c
+}
+
 // SetNotNull adds "not null" to the create table statements for this
 // column, if nn is true.
 func (c *ColumnMap) SetNotNull(nn bool) *ColumnMap {
@@ -74,3 +102,51 @@ func (c *ColumnMap) SetMaxSize(size int) *ColumnMap {
 	c.MaxSize = size
 	return c
 }
+
+// SetForeignKey adds a foreign key constraint referencing column of table
+// to the create table statements for this column.  table may be qualified
+// by its schema, as in "schema.table".  CreateTables creates referenced
+// tables first.
+//
+// Example:  table.ColMap("PersonId").SetForeignKey("person", "id").SetOnDelete("cascade")
+//
+func (c *ColumnMap) SetForeignKey(table, column string) *ForeignKey {
+	c.ForeignKey = &ForeignKey{Table: table, Column: column}
+	return c.ForeignKey
+}
+
+// ForeignKey represents the table and column referenced by a ColumnMap.
+type ForeignKey struct {
+	// Referenced table, optionally qualified by its schema
+	Table string
+
+	// Referenced column
+	Column string
+
+	// Referential actions, such as "cascade" or "set null".  Empty
+	// actions are left to the database default.
+	OnDelete string
+	OnUpdate string
+}
+
+// SetOnDelete specifies the action taken when the referenced row is
+// deleted.
+func (fk *ForeignKey) SetOnDelete(action string) *ForeignKey {
+	fk.OnDelete = action
+	return fk
+}
+
+// SetOnUpdate specifies the action taken when the referenced key is
+// updated.
+func (fk *ForeignKey) SetOnUpdate(action string) *ForeignKey {
+	fk.OnUpdate = action
+	return fk
+}
+
+// schemaAndTable splits the referenced table into its schema and name
+func (fk *ForeignKey) schemaAndTable() (string, string) {
+	if i := strings.LastIndexByte(fk.Table, '.'); i >= 0 {
+		return fk.Table[:i], fk.Table[i+1:]
+	}
+	return "", fk.Table
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"log"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -28,7 +29,8 @@ import (
 //     dbmap := &gorp.DbMap{Db: db, Dialect: dialect}
 //
 type DbMap struct {
-	ctx context.Context
+	ctx      context.Context
+	unscoped bool
 
 	// Db handle to use with this map
 	Db *sql.DB
@@ -89,10 +91,33 @@ type DbMap struct {
 	//     }
 	ExpandSliceArgs bool
 
+	// Now returns the current time for the timestamp and soft delete
+	// columns set by gorp.  It defaults to time.Now; set it for
+	// deterministic tests.  Times are stored in UTC, truncated to the
+	// precision of the Dialect if it implements TimePrecisioner.
+	Now func() time.Time
+
+	// TxRetry controls how RunInTx retries transactions failing with
+	// retryable errors.  DefaultRetryPolicy is used if it is nil.
+	TxRetry *RetryPolicy
+
+	// Cache, if set, caches the rows fetched by Get.  See Cache.
+	Cache Cache
+
+	// Replicas, if set, sends reads outside of Transactions to read
+	// replicas of Db.  See Replicas.
+	Replicas *Replicas
+
 	tables        []*TableMap
-	tablesDynamic map[string]*TableMap // tables that use same go-struct and different db table names
+	tablesByType  map[reflect.Type]*TableMap // index of tables, so lookups by type don't scan the list
+	tablesDynamic map[string]*TableMap       // tables that use same go-struct and different db table names
+	bookkeeping   map[string]*TableMap       // gorp's own tables, built on first use; see bookkeepingTable
 	logger        GorpLogger
 	logPrefix     string
+	interceptors  []Interceptor
+	stats         *Stats
+	method        string // the method reported to interceptors; see withCall
+	table         string // the table reported to interceptors; see withCall
 }
 
 func (m *DbMap) dynamicTableAdd(tableName string, tbl *TableMap) {
@@ -151,6 +176,13 @@ func (m *DbMap) CreateIndex() error {
 func (m *DbMap) createIndexImpl(dialect reflect.Type,
 	table *TableMap,
 	index *IndexMap) error {
+	_, err := m.Exec(m.sqlForCreateIndex(dialect, table, index))
+	return err
+}
+
+func (m *DbMap) sqlForCreateIndex(dialect reflect.Type,
+	table *TableMap,
+	index *IndexMap) string {
 	s := bytes.Buffer{}
 	s.WriteString("create")
 	if index.Unique {
@@ -174,24 +206,18 @@ func (m *DbMap) createIndexImpl(dialect reflect.Type,
 		s.WriteString(fmt.Sprintf(" %s %s", m.Dialect.CreateIndexSuffix(), index.IndexType))
 	}
 	s.WriteString(";")
-	_, err := m.Exec(s.String())
-	return err
+	return s.String()
 }
 
+// DropIndex drops the index named name, added with AddIndex, on the
+// DbMap of the table.  Use ShardedDbMap.DropIndex for the tables of a
+// ShardedDbMap, which belong to its first shard.
 func (t *TableMap) DropIndex(name string) error {
 
 	var err error
-	dialect := reflect.TypeOf(t.dbmap.Dialect)
 	for _, idx := range t.indexes {
 		if idx.IndexName == name {
-			s := bytes.Buffer{}
-			s.WriteString(fmt.Sprintf("DROP INDEX %s", idx.IndexName))
-
-			if dname := dialect.Name(); dname == "MySQLDialect" {
-				s.WriteString(fmt.Sprintf(" %s %s", t.dbmap.Dialect.DropIndexSuffix(), t.TableName))
-			}
-			s.WriteString(";")
-			_, e := t.dbmap.Exec(s.String())
+			_, e := t.dbmap.Exec(t.sqlForDropIndex(idx.IndexName))
 			if e != nil {
 				err = e
 			}
@@ -202,6 +228,18 @@ func (t *TableMap) DropIndex(name string) error {
 	return err
 }
 
+func (t *TableMap) sqlForDropIndex(name string) string {
+	dialect := reflect.TypeOf(t.dbmap.Dialect)
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("DROP INDEX %s", name))
+
+	if dname := dialect.Name(); dname == "MySQLDialect" {
+		s.WriteString(fmt.Sprintf(" %s %s", t.dbmap.Dialect.DropIndexSuffix(), t.TableName))
+	}
+	s.WriteString(";")
+	return s.String()
+}
+
 // AddTable registers the given interface type with gorp. The table name
 // will be given the name of the TypeOf(i).  You must call this function,
 // or AddTableWithName, for any struct type you wish to persist with
@@ -229,18 +267,19 @@ func (m *DbMap) AddTableWithNameAndSchema(i interface{}, schema string, name str
 
 	// check if we have a table for this type already
 	// if so, update the name and return the existing pointer
-	for i := range m.tables {
-		table := m.tables[i]
-		if table.gotype == t {
-			table.TableName = name
-			return table
-		}
+	if table, found := m.tablesByType[t]; found {
+		table.TableName = name
+		return table
 	}
 
 	tmap := &TableMap{gotype: t, TableName: name, SchemaName: schema, dbmap: m}
 	var primaryKey []*ColumnMap
 	tmap.Columns, primaryKey = m.readStructColumns(t)
 	m.tables = append(m.tables, tmap)
+	if m.tablesByType == nil {
+		m.tablesByType = make(map[reflect.Type]*TableMap)
+	}
+	m.tablesByType[t] = tmap
 	if len(primaryKey) > 0 {
 		tmap.keys = append(tmap.keys, primaryKey...)
 	}
@@ -283,6 +322,9 @@ func (m *DbMap) readStructColumns(t reflect.Type) (cols []*ColumnMap, primaryKey
 	n := t.NumField()
 	for i := 0; i < n; i++ {
 		f := t.Field(i)
+		if f.Type == snapshotType {
+			continue
+		}
 		if f.Anonymous && f.Type.Kind() == reflect.Struct {
 			// Recursively add nested fields in embedded structs.
 			subcols, subpk := m.readStructColumns(f.Type)
@@ -313,13 +355,16 @@ func (m *DbMap) readStructColumns(t reflect.Type) (cols []*ColumnMap, primaryKey
 			var isAuto bool
 			var isPK bool
 			var isNotNull bool
+			var isCreateTime, isUpdateTime bool
+			var isSensitive bool
+			var foreignKey *ForeignKey
 			for _, argString := range cArguments[1:] {
 				argString = strings.TrimSpace(argString)
 				arg := strings.SplitN(argString, ":", 2)
 
 				// check mandatory/unexpected option values
 				switch arg[0] {
-				case "size", "default":
+				case "size", "default", "references", "ondelete", "onupdate":
 					// options requiring value
 					if len(arg) == 1 {
 						panic(fmt.Sprintf("missing option value for option %v on field %v", arg[0], f.Name))
@@ -342,10 +387,39 @@ func (m *DbMap) readStructColumns(t reflect.Type) (cols []*ColumnMap, primaryKey
 					isAuto = true
 				case "notnull":
 					isNotNull = true
+				case "autocreatetime":
+					isCreateTime = true
+				case "autoupdatetime":
+					isUpdateTime = true
+				case "sensitive":
+					isSensitive = true
+				case "references":
+					// references:table.column, where table may be
+					// qualified by its schema
+					i := strings.LastIndexByte(arg[1], '.')
+					if i <= 0 || i == len(arg[1])-1 {
+						panic(fmt.Sprintf("invalid references option %v on field %v, want table.column", arg[1], f.Name))
+					}
+					if foreignKey == nil {
+						foreignKey = &ForeignKey{}
+					}
+					foreignKey.Table, foreignKey.Column = arg[1][:i], arg[1][i+1:]
+				case "ondelete", "onupdate":
+					if foreignKey == nil {
+						foreignKey = &ForeignKey{}
+					}
+					if arg[0] == "ondelete" {
+						foreignKey.OnDelete = arg[1]
+					} else {
+						foreignKey.OnUpdate = arg[1]
+					}
 				default:
 					panic(fmt.Sprintf("Unrecognized tag option for field %v: %v", f.Name, arg))
 				}
 			}
+			if foreignKey != nil && foreignKey.Table == "" {
+				panic(fmt.Sprintf("ondelete and onupdate options require a references option on field %v", f.Name))
+			}
 			if columnName == "" {
 				columnName = f.Name
 			}
@@ -390,6 +464,13 @@ func (m *DbMap) readStructColumns(t reflect.Type) (cols []*ColumnMap, primaryKey
 				isAutoIncr:   isAuto,
 				isNotNull:    isNotNull,
 				MaxSize:      maxSize,
+				ForeignKey:   foreignKey,
+				isCreateTime: isCreateTime,
+				isUpdateTime: isUpdateTime,
+				sensitive:    isSensitive,
+			}
+			if (isCreateTime || isUpdateTime) && !isTimeType(f.Type) {
+				panic(fmt.Sprintf("autocreatetime and autoupdatetime options require a time field, got %v on field %v", f.Type, f.Name))
 			}
 			if isPK {
 				primaryKey = append(primaryKey, cm)
@@ -431,8 +512,7 @@ func (m *DbMap) CreateTablesIfNotExists() error {
 
 func (m *DbMap) createTables(ifNotExists bool) error {
 	var err error
-	for i := range m.tables {
-		table := m.tables[i]
+	for _, table := range m.tablesInDependencyOrder() {
 		sql := table.SqlForCreate(ifNotExists)
 		_, err = m.Exec(sql)
 		if err != nil {
@@ -440,15 +520,57 @@ func (m *DbMap) createTables(ifNotExists bool) error {
 		}
 	}
 
-	for _, tbl := range m.dynamicTableMap() {
-		sql := tbl.SqlForCreate(ifNotExists)
-		_, err = m.Exec(sql)
-		if err != nil {
-			return err
-		}
+	return err
+}
+
+// tablesInDependencyOrder returns the registered tables, with tables
+// referenced by foreign keys before the tables referencing them.  Tables
+// are otherwise kept in the order they were added, followed by dynamic
+// tables sorted by name.  Tables in a reference cycle keep that order.
+func (m *DbMap) tablesInDependencyOrder() []*TableMap {
+	tables := append([]*TableMap{}, m.tables...)
+	dynamic := m.dynamicTableMap()
+	names := make([]string, 0, len(dynamic))
+	for name := range dynamic {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tables = append(tables, dynamic[name])
 	}
 
-	return err
+	ordered := make([]*TableMap, 0, len(tables))
+	done := make(map[*TableMap]bool, len(tables))
+	for len(ordered) < len(tables) {
+		progress := false
+		for _, t := range tables {
+			if done[t] {
+				continue
+			}
+			ready := true
+			for _, other := range tables {
+				if other != t && !done[other] && t.references(other) {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				ordered = append(ordered, t)
+				done[t] = true
+				progress = true
+			}
+		}
+		if !progress {
+			// a reference cycle: add the remaining tables as they are
+			for _, t := range tables {
+				if !done[t] {
+					ordered = append(ordered, t)
+					done[t] = true
+				}
+			}
+		}
+	}
+	return ordered
 }
 
 // DropTable drops an individual table.
@@ -488,19 +610,14 @@ func (m *DbMap) DropTablesIfExists() error {
 	return m.dropTables(true)
 }
 
-// Goes through all the registered tables, dropping them one by one.
+// Goes through all the registered tables, dropping them one by one,
+// with tables referencing others by foreign keys first.
 // If an error is encountered, then it is returned and the rest of
 // the tables are not dropped.
 func (m *DbMap) dropTables(addIfExists bool) (err error) {
-	for _, table := range m.tables {
-		err = m.dropTableImpl(table, addIfExists)
-		if err != nil {
-			return err
-		}
-	}
-
-	for _, table := range m.dynamicTableMap() {
-		err = m.dropTableImpl(table, addIfExists)
+	tables := m.tablesInDependencyOrder()
+	for i := len(tables) - 1; i >= 0; i-- {
+		err = m.dropTableImpl(tables[i], addIfExists)
 		if err != nil {
 			return err
 		}
@@ -566,13 +683,70 @@ func (m *DbMap) Insert(list ...interface{}) error {
 	return insert(m, m, list...)
 }
 
+// InsertBatch inserts the elements in list using multi-row
+// "insert ... values (...), (...)" statements.  List items must be
+// pointers, and may belong to different tables; a statement is issued per
+// table, split as needed to stay within the bind variable limit of the
+// dialect.
+//
+// Auto-increment keys are bound to the PK field of every struct, as with
+// Insert.  Tables whose dialect does not implement BatchInserter, or whose
+// auto-increment keys cannot be recovered from a multi-row insert, are
+// inserted one row at a time.
+//
+// The hook functions PreInsert() and/or PostInsert() will be executed
+// before/after each statement for every interface that defines them.
+//
+// Panics if any interface in the list has not been registered with AddTable
+func (m *DbMap) InsertBatch(list ...interface{}) error {
+	return insertBatch(m, m, list...)
+}
+
+// Upsert runs a SQL statement for each element in list that inserts the
+// row, or updates the existing row with the same primary key.  List items
+// must be pointers.
+//
+// The statement is generated by the dialect, which must implement
+// Upserter: "on conflict do update" for Postgres and sqlite, "on
+// duplicate key update" for MySQL and "merge" for SQL Server, Oracle and
+// Snowflake.  Every non-key column is overwritten on conflict, except
+// columns with a default value.  Auto-increment keys are bound from the
+// struct, unless they are zero: such rows are new, and are inserted as by
+// Insert, with a key generated by the database and set on the struct.
+//
+// If the table has a version column, a conflicting row is only updated
+// if its version matches the struct's, and an OptimisticLockError is
+// returned otherwise.
+//
+// The hook functions PreInsert() and/or PostInsert() will be executed
+// before/after the statement if the interface defines them.
+//
+// Returns an error if SetKeys has not been called on the TableMap
+// Panics if any interface in the list has not been registered with AddTable
+func (m *DbMap) Upsert(list ...interface{}) error {
+	return upsert(m, m, "", nil, list...)
+}
+
+// UpsertColumns has the same behavior as Upsert, but conflicts on the
+// unique constraint registered with TableMap.SetUniqueTogetherWithName
+// under the name conflict, or on the primary key if conflict is empty.
+// Only the columns accepted by filter are overwritten on conflict.
+//
+// When conflicting on a unique constraint, auto-increment keys are
+// generated by the database and are not bound to the struct.
+func (m *DbMap) UpsertColumns(conflict string, filter ColumnFilter, list ...interface{}) error {
+	return upsert(m, m, conflict, filter, list...)
+}
+
 // Update runs a SQL UPDATE statement for each element in list.  List
 // items must be pointers.
 //
 // The hook functions PreUpdate() and/or PostUpdate() will be executed
 // before/after the UPDATE statement if the interface defines them.
 //
-// Returns the number of rows updated.
+// Returns the number of rows updated.  Rows embedding a Snapshot none of
+// whose columns changed are not written and not counted, but their hooks
+// are still called.
 //
 // Returns an error if SetKeys has not been called on the TableMap
 // Panics if any interface in the list has not been registered with AddTable
@@ -604,10 +778,13 @@ func (m *DbMap) UpdateColumns(filter ColumnFilter, list ...interface{}) (int64,
 //
 // Returns the number of rows deleted.
 //
+// For tables with a soft delete column, rows are marked as deleted
+// instead; see TableMap.SetSoftDeleteCol.
+//
 // Returns an error if SetKeys has not been called on the TableMap
 // Panics if any interface in the list has not been registered with AddTable
 func (m *DbMap) Delete(list ...interface{}) (int64, error) {
-	return delete(m, m, list...)
+	return deleteRows(m, m, false, list...)
 }
 
 // Get runs a SQL SELECT to fetch a single row from the table based on the
@@ -735,18 +912,47 @@ func (m *DbMap) SelectOne(holder interface{}, query string, args ...interface{})
 
 // Begin starts a gorp Transaction
 func (m *DbMap) Begin() (*Transaction, error) {
+	return m.beginTx(nil)
+}
+
+// BeginTx starts a gorp Transaction with the context ctx and the options
+// opts, such as its isolation level or whether it is read only.  Either
+// may be nil; the transaction then runs with the context of m and the
+// default options of the driver.  Drivers return an error if they don't
+// support the options.
+//
+// The Transaction runs its statements with ctx, and is rolled back if
+// ctx is done before it is committed.
+func (m *DbMap) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Transaction, error) {
+	db := m
+	if ctx != nil {
+		db = m.WithContext(ctx).(*DbMap)
+	}
+	return db.beginTx(opts)
+}
+
+// beginTx starts a gorp Transaction with the options opts, which may be
+// nil.
+func (m *DbMap) beginTx(opts *sql.TxOptions) (*Transaction, error) {
 	if m.logger != nil {
 		now := time.Now()
-		defer m.trace(now, "begin;")
+		defer m.trace(now, beginQuery(opts)+";")
 	}
-	tx, err := begin(m)
+	tx, ctx, err := begin(m, opts)
 	if err != nil {
 		return nil, err
 	}
+	var options sql.TxOptions
+	if opts != nil {
+		options = *opts
+	}
 	return &Transaction{
-		dbmap:  m,
-		tx:     tx,
-		closed: false,
+		ctx:      ctx,
+		opts:     options,
+		dbmap:    m,
+		tx:       tx,
+		state:    &txState{},
+		uncached: new([]string),
 	}, nil
 }
 
@@ -807,13 +1013,7 @@ func tableOrNil(m *DbMap, t reflect.Type, name string) *TableMap {
 		return nil
 	}
 
-	for i := range m.tables {
-		table := m.tables[i]
-		if table.gotype == t {
-			return table
-		}
-	}
-	return nil
+	return m.tablesByType[t]
 }
 
 func (m *DbMap) tableForPointer(ptr interface{}, checkPK bool) (*TableMap, reflect.Value, error) {
@@ -872,7 +1072,9 @@ func (m *DbMap) trace(started time.Time, query string, args ...interface{}) {
 		expandSliceArgs(&query, args...)
 	}
 
-	if m.logger != nil {
+	if l, ok := m.logger.(queryLogger); ok {
+		l.logQuery(m.logPrefix, query, args, time.Since(started))
+	} else if m.logger != nil {
 		var margs = argsString(args...)
 		m.logger.Printf("%s%s [%s] (%v)", m.logPrefix, query, margs, (time.Now().Sub(started)))
 	}
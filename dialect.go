@@ -5,7 +5,13 @@
 package gorp
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
 	"reflect"
+	"strings"
+	"time"
 )
 
 // The Dialect interface encapsulates behaviors that differ across
@@ -103,3 +109,351 @@ func standardInsertAutoIncr(exec SqlExecutor, insertSql string, params ...interf
 	}
 	return res.LastInsertId()
 }
+
+// BatchInserter is implemented by dialects that support multi-row
+// "insert ... values (...), (...)" statements.  MaxBindVars returns the
+// largest number of bind variables the database accepts in a single
+// statement; InsertBatch splits larger batches across several statements.
+type BatchInserter interface {
+	MaxBindVars() int
+}
+
+// IntegerAutoIncrBatchInserter is the multi-row counterpart of
+// IntegerAutoIncrInserter.  It runs an insert of rows rows and returns
+// the automatically incremented keys in row order.
+type IntegerAutoIncrBatchInserter interface {
+	InsertAutoIncrBatch(exec SqlExecutor, insertSql string, rows int, params ...interface{}) ([]int64, error)
+}
+
+// TargetedAutoIncrBatchInserter is the multi-row counterpart of
+// TargetedAutoIncrInserter.  targets holds a pointer to the primary key
+// field of each row being inserted, in row order.
+type TargetedAutoIncrBatchInserter interface {
+	InsertAutoIncrToTargets(exec SqlExecutor, insertSql string, targets []interface{}, params ...interface{}) error
+}
+
+// consecutiveIds returns rows ids counting up from first.
+func consecutiveIds(first int64, rows int) []int64 {
+	ids := make([]int64, rows)
+	for i := range ids {
+		ids[i] = first + int64(i)
+	}
+	return ids
+}
+
+func insertAutoIncrToTargets(exec SqlExecutor, insertSql string, targets []interface{}, params ...interface{}) error {
+	rows, err := exec.Query(insertSql, params...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for _, target := range targets {
+		if !rows.Next() {
+			return fmt.Errorf("gorp: expected %d serial values for insert, got fewer: %s Encountered error: %v", len(targets), insertSql, rows.Err())
+		}
+		if err := rows.Scan(target); err != nil {
+			return err
+		}
+	}
+	if rows.Next() {
+		return fmt.Errorf("gorp: more than %d serial values returned for insert: %s", len(targets), insertSql)
+	}
+	return rows.Err()
+}
+
+// Upserter is implemented by dialects that can insert a row, or update
+// the existing row it conflicts with, in a single statement.
+type Upserter interface {
+	// UpsertSql returns a statement that inserts cols into table, with
+	// vals holding the value expression (usually a bind variable) of each
+	// column.  If the row conflicts with an existing row on the conflict
+	// columns, the update columns of that row are overwritten instead.
+	//
+	// If version is not empty, the update only applies if the existing
+	// row's version column is one less than the version being written.
+	// The version column is then also the last of the update columns.
+	//
+	// table is already quoted; column names are not.
+	UpsertSql(table string, cols, vals, conflict, update []string, version string) string
+}
+
+// onConflictUpsertSql returns an "insert ... on conflict do update"
+// statement, as supported by Postgres and sqlite.
+func onConflictUpsertSql(d Dialect, table string, cols, vals, conflict, update []string, version string) string {
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("insert into %s (%s) values (%s) on conflict (%s)",
+		table, quoteFields(d, cols), strings.Join(vals, ","), quoteFields(d, conflict)))
+	if len(update) == 0 {
+		s.WriteString(" do nothing")
+	} else {
+		s.WriteString(" do update set ")
+		for i, col := range update {
+			if i > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString(fmt.Sprintf("%s=excluded.%s", d.QuoteField(col), d.QuoteField(col)))
+		}
+		if version != "" {
+			s.WriteString(fmt.Sprintf(" where %s.%s=excluded.%s-1", table, d.QuoteField(version), d.QuoteField(version)))
+		}
+	}
+	s.WriteString(d.QuerySuffix())
+	return s.String()
+}
+
+// mergeUpsertSql returns a "merge" statement upserting from source, a
+// derived table aliased as s that holds a single row with the values of
+// cols.  If versionInWhere is set the version condition is written as a
+// where clause on the update, otherwise it is added to the "when matched"
+// condition.
+func mergeUpsertSql(d Dialect, table, source string, cols, conflict, update []string, version string, versionInWhere bool) string {
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("merge into %s t using %s on (", table, source))
+	for i, col := range conflict {
+		if i > 0 {
+			s.WriteString(" and ")
+		}
+		s.WriteString(fmt.Sprintf("t.%s=s.%s", d.QuoteField(col), d.QuoteField(col)))
+	}
+	s.WriteString(")")
+
+	if len(update) > 0 {
+		versionCond := ""
+		if version != "" {
+			versionCond = fmt.Sprintf("t.%s=s.%s-1", d.QuoteField(version), d.QuoteField(version))
+		}
+		s.WriteString(" when matched")
+		if versionCond != "" && !versionInWhere {
+			s.WriteString(" and " + versionCond)
+		}
+		s.WriteString(" then update set ")
+		for i, col := range update {
+			if i > 0 {
+				s.WriteString(", ")
+			}
+			s.WriteString(fmt.Sprintf("%s=s.%s", d.QuoteField(col), d.QuoteField(col)))
+		}
+		if versionCond != "" && versionInWhere {
+			s.WriteString(" where " + versionCond)
+		}
+	}
+
+	sourceCols := make([]string, len(cols))
+	for i, col := range cols {
+		sourceCols[i] = "s." + d.QuoteField(col)
+	}
+	s.WriteString(fmt.Sprintf(" when not matched then insert (%s) values (%s)",
+		quoteFields(d, cols), strings.Join(sourceCols, ",")))
+	s.WriteString(d.QuerySuffix())
+	return s.String()
+}
+
+// quoteFields quotes each of fields and joins them into a comma separated
+// list.
+func quoteFields(d Dialect, fields []string) string {
+	quoted := make([]string, len(fields))
+	for i, field := range fields {
+		quoted[i] = d.QuoteField(field)
+	}
+	return strings.Join(quoted, ",")
+}
+
+// CatalogColumn describes a column of an existing table, as read from the
+// database catalog by a SchemaInspector.
+type CatalogColumn struct {
+	Name string
+
+	// Type is the column type, spelled the way the dialect's ToSqlType
+	// would spell it where possible.
+	Type string
+
+	NotNull bool
+
+	// Default is the default expression of the column, or "" if it has
+	// none.
+	Default string
+}
+
+// CatalogIndex describes an index of an existing table, as read from the
+// database catalog by a SchemaInspector.
+type CatalogIndex struct {
+	Name    string
+	Unique  bool
+	Columns []string
+
+	// Constraint is true if the index backs a unique constraint of the
+	// table definition, and so cannot be dropped with "drop index".
+	Constraint bool
+}
+
+// SchemaInspector is implemented by dialects that can read the definition
+// of existing tables from the database catalog.  It is required by
+// DbMap.PlanSchema.
+type SchemaInspector interface {
+	// TableColumns returns the columns of the given table in table
+	// order, or nil if the table does not exist.
+	TableColumns(exec SqlExecutor, schema, table string) ([]CatalogColumn, error)
+
+	// TableIndexes returns the indexes of the given table, other than the
+	// one backing its primary key.
+	TableIndexes(exec SqlExecutor, schema, table string) ([]CatalogIndex, error)
+}
+
+// SchemaAlterer is implemented by dialects that can change the columns of
+// existing tables.  It is required by DbMap.PlanSchema.
+//
+// table is the quoted table name; column names are not quoted.
+type SchemaAlterer interface {
+	// AddColumnSql returns a statement adding a column to table.  def is
+	// the column definition: the quoted name, type and constraints.
+	AddColumnSql(table, def string) string
+
+	// AlterColumnSql returns the statements changing the type and
+	// nullability of column, and its default if defaultValue is not
+	// empty.  It returns nil if the dialect cannot alter columns.
+	AlterColumnSql(table, column, sqlType string, notNull bool, defaultValue string) []string
+
+	// DropColumnSql returns a statement dropping column from table.
+	DropColumnSql(table, column string) string
+}
+
+func standardAddColumnSql(d Dialect, table, def string) string {
+	return fmt.Sprintf("alter table %s add column %s%s", table, def, d.QuerySuffix())
+}
+
+func standardDropColumnSql(d Dialect, table, column string) string {
+	return fmt.Sprintf("alter table %s drop column %s%s", table, d.QuoteField(column), d.QuerySuffix())
+}
+
+// informationSchemaColumns reads the columns of a table from the standard
+// information_schema.columns view.  schemaExpr is the SQL expression
+// naming the default schema, used when schema is empty.  The type of
+// each column is read from the typeExpr expression.
+func informationSchemaColumns(d Dialect, exec SqlExecutor, schemaExpr, typeExpr, schema, table string) ([]CatalogColumn, error) {
+	query := fmt.Sprintf("select column_name, %s, is_nullable, column_default from information_schema.columns"+
+		" where table_schema = coalesce(nullif(%s, ''), %s) and table_name = %s order by ordinal_position",
+		typeExpr, d.BindVar(0), schemaExpr, d.BindVar(1))
+	rows, err := exec.Query(query, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []CatalogColumn
+	for rows.Next() {
+		var (
+			col        CatalogColumn
+			isNullable string
+			def        sql.NullString
+		)
+		if err := rows.Scan(&col.Name, &col.Type, &isNullable, &def); err != nil {
+			return nil, err
+		}
+		col.NotNull = isNullable == "NO"
+		col.Default = def.String
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+// AdvisoryLocker is implemented by dialects that can take a named lock
+// shared by all clients of the database.  Lock blocks until the lock is
+// acquired or ctx is done.  Both methods run on conn, which is held for
+// as long as the lock is.
+type AdvisoryLocker interface {
+	AdvisoryLock(ctx context.Context, conn *sql.Conn, name string) error
+	AdvisoryUnlock(ctx context.Context, conn *sql.Conn, name string) error
+}
+
+// Paginator is implemented by dialects whose syntax for paging through
+// the rows of a query differs from the "limit N offset M" used by
+// default.
+type Paginator interface {
+	// PageSql returns query limited to at most limit rows after skipping
+	// offset rows.  A negative limit means no limit.
+	PageSql(query string, limit, offset int) string
+
+	// CountSql returns a query counting the rows returned by query.
+	CountSql(query string) string
+}
+
+func pageSql(d Dialect, query string, limit, offset int) string {
+	query = trimQuerySuffix(d, query)
+	if p, ok := d.(Paginator); ok {
+		return p.PageSql(query, limit, offset) + d.QuerySuffix()
+	}
+	if limit >= 0 {
+		query += fmt.Sprintf(" limit %d", limit)
+	}
+	if offset > 0 {
+		query += fmt.Sprintf(" offset %d", offset)
+	}
+	return query + d.QuerySuffix()
+}
+
+func countSql(d Dialect, query string) string {
+	query = trimQuerySuffix(d, query)
+	if p, ok := d.(Paginator); ok {
+		return p.CountSql(query) + d.QuerySuffix()
+	}
+	return fmt.Sprintf("select count(*) from (%s) gorp_count%s", query, d.QuerySuffix())
+}
+
+// offsetFetchSql pages query with the standard "offset ... fetch next"
+// clause.
+func offsetFetchSql(query string, limit, offset int) string {
+	query += fmt.Sprintf(" offset %d rows", offset)
+	if limit >= 0 {
+		query += fmt.Sprintf(" fetch next %d rows only", limit)
+	}
+	return query
+}
+
+func trimQuerySuffix(d Dialect, query string) string {
+	query = strings.TrimSpace(query)
+	if suffix := d.QuerySuffix(); suffix != "" {
+		query = strings.TrimSpace(strings.TrimSuffix(query, suffix))
+	}
+	return query
+}
+
+// ForeignKeyDialect is implemented by dialects whose foreign key
+// constraints differ from the standard
+// "foreign key (c) references t (c) on delete ... on update ..." clause.
+//
+// column and refColumn are quoted, as is refTable.
+type ForeignKeyDialect interface {
+	ForeignKeySql(column, refTable, refColumn, onDelete, onUpdate string) string
+}
+
+func foreignKeySql(d Dialect, column, refTable, refColumn, onDelete, onUpdate string) string {
+	if fd, ok := d.(ForeignKeyDialect); ok {
+		return fd.ForeignKeySql(column, refTable, refColumn, onDelete, onUpdate)
+	}
+	s := fmt.Sprintf("foreign key (%s) references %s (%s)", column, refTable, refColumn)
+	if onDelete != "" {
+		s += " on delete " + onDelete
+	}
+	if onUpdate != "" {
+		s += " on update " + onUpdate
+	}
+	return s
+}
+
+// RetryClassifier is implemented by dialects recognizing the errors of
+// transactions that may succeed if run again, such as serialization
+// failures and deadlocks.  RunInTx retries transactions failing with
+// them.  To retry other errors, embed a dialect in a type overriding
+// IsRetryable.
+type RetryClassifier interface {
+	IsRetryable(err error) bool
+}
+
+// TimePrecisioner is implemented by dialects whose timestamp columns
+// store times at a coarser precision than a nanosecond.  Times set by
+// gorp, such as those of timestamp columns, are truncated to it so that
+// the struct holds the value stored in the database.
+type TimePrecisioner interface {
+	TimePrecision() time.Duration
+}
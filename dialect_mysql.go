@@ -5,8 +5,12 @@
 package gorp
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -144,6 +148,119 @@ func (d MySQLDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, params
 	return standardInsertAutoIncr(exec, insertSql, params...)
 }
 
+// InsertAutoIncrBatch relies on MySQL reporting the id of the first row of
+// a multi-row insert and allocating the remaining ids consecutively, which
+// holds as long as auto_increment_increment is 1.
+func (d MySQLDialect) InsertAutoIncrBatch(exec SqlExecutor, insertSql string, rows int, params ...interface{}) ([]int64, error) {
+	first, err := standardInsertAutoIncr(exec, insertSql, params...)
+	if err != nil {
+		return nil, err
+	}
+	return consecutiveIds(first, rows), nil
+}
+
+// Returns 65535, the limit of the MySQL prepared statement protocol
+func (d MySQLDialect) MaxBindVars() int {
+	return 65535
+}
+
+// UpsertSql returns an "insert ... on duplicate key update" statement.
+// MySQL offers no way to choose the conflict target: a row conflicts on
+// any primary key or unique index, so conflict is ignored other than to
+// produce a no-op update when there are no columns to update.
+func (d MySQLDialect) UpsertSql(table string, cols, vals, conflict, update []string, version string) string {
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("insert into %s (%s) values (%s) on duplicate key update ",
+		table, quoteFields(d, cols), strings.Join(vals, ",")))
+	if len(update) == 0 {
+		col := d.QuoteField(conflict[0])
+		s.WriteString(fmt.Sprintf("%s=%s", col, col))
+	}
+	for i, col := range update {
+		if i > 0 {
+			s.WriteString(", ")
+		}
+		q := d.QuoteField(col)
+		if version == "" {
+			s.WriteString(fmt.Sprintf("%s=values(%s)", q, q))
+		} else {
+			// MySQL applies the assignments from left to right, so the
+			// version column, which comes last, is compared before it is
+			// overwritten.
+			v := d.QuoteField(version)
+			s.WriteString(fmt.Sprintf("%s=if(%s=values(%s)-1,values(%s),%s)", q, v, v, q, q))
+		}
+	}
+	s.WriteString(d.QuerySuffix())
+	return s.String()
+}
+
+// mysqlIntWidthRegexp matches the display width MySQL reports for integer
+// column types, as in int(11)
+var mysqlIntWidthRegexp = regexp.MustCompile(`^(tinyint|smallint|mediumint|int|bigint)\(\d+\)`)
+
+func (d MySQLDialect) TableColumns(exec SqlExecutor, schema, table string) ([]CatalogColumn, error) {
+	cols, err := informationSchemaColumns(d, exec, "database()", "column_type", schema, table)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cols {
+		typ := strings.ToLower(cols[i].Type)
+		if typ == "tinyint(1)" {
+			// MySQL's boolean is an alias for tinyint(1)
+			typ = "boolean"
+		}
+		cols[i].Type = mysqlIntWidthRegexp.ReplaceAllString(typ, "$1")
+	}
+	return cols, nil
+}
+
+func (d MySQLDialect) TableIndexes(exec SqlExecutor, schema, table string) ([]CatalogIndex, error) {
+	rows, err := exec.Query("select index_name, non_unique, column_name from information_schema.statistics"+
+		" where table_schema = coalesce(nullif(?, ''), database()) and table_name = ? and index_name <> 'PRIMARY'"+
+		" order by index_name, seq_in_index", schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []CatalogIndex
+	for rows.Next() {
+		var (
+			name, col string
+			nonUnique bool
+		)
+		if err := rows.Scan(&name, &nonUnique, &col); err != nil {
+			return nil, err
+		}
+		if n := len(indexes); n > 0 && indexes[n-1].Name == name {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, col)
+			continue
+		}
+		indexes = append(indexes, CatalogIndex{Name: name, Unique: !nonUnique, Columns: []string{col}})
+	}
+	return indexes, rows.Err()
+}
+
+func (d MySQLDialect) AddColumnSql(table, def string) string {
+	return standardAddColumnSql(d, table, def)
+}
+
+func (d MySQLDialect) AlterColumnSql(table, column, sqlType string, notNull bool, defaultValue string) []string {
+	s := fmt.Sprintf("alter table %s modify column %s %s", table, d.QuoteField(column), sqlType)
+	if notNull {
+		s += " not null"
+	}
+	if defaultValue != "" {
+		s += " default " + defaultValue
+	}
+	return []string{s + d.QuerySuffix()}
+}
+
+func (d MySQLDialect) DropColumnSql(table, column string) string {
+	return standardDropColumnSql(d, table, column)
+}
+
 func (d MySQLDialect) QuoteField(f string) string {
 	return "`" + f + "`"
 }
@@ -167,3 +284,52 @@ func (d MySQLDialect) IfTableExists(command, schema, table string) string {
 func (d MySQLDialect) IfTableNotExists(command, schema, table string) string {
 	return fmt.Sprintf("%s if not exists", command)
 }
+
+// AdvisoryLock takes the named lock with GET_LOCK, waiting for as long as
+// ctx allows.
+func (d MySQLDialect) AdvisoryLock(ctx context.Context, conn *sql.Conn, name string) error {
+	var ok sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "select get_lock(?, -1)", name).Scan(&ok); err != nil {
+		return err
+	}
+	if ok.Int64 != 1 {
+		return fmt.Errorf("gorp: could not get lock %s", name)
+	}
+	return nil
+}
+
+func (d MySQLDialect) AdvisoryUnlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "select release_lock(?)", name)
+	return err
+}
+
+// MySQL requires a limit before an offset, so the largest possible limit
+// stands in for no limit.
+func (d MySQLDialect) PageSql(query string, limit, offset int) string {
+	if limit >= 0 {
+		query += fmt.Sprintf(" limit %d", limit)
+	} else if offset > 0 {
+		query += " limit 18446744073709551615"
+	}
+	if offset > 0 {
+		query += fmt.Sprintf(" offset %d", offset)
+	}
+	return query
+}
+
+func (d MySQLDialect) CountSql(query string) string {
+	return fmt.Sprintf("select count(*) from (%s) as gorp_count", query)
+}
+
+// TimePrecision returns a second, the precision of datetime columns.
+func (d MySQLDialect) TimePrecision() time.Duration {
+	return time.Second
+}
+
+// IsRetryable reports whether err is a deadlock (error 1213) or a lock
+// wait timeout (1205), for drivers whose errors hold the error number in
+// a Number field, such as go-sql-driver/mysql.
+func (d MySQLDialect) IsRetryable(err error) bool {
+	n, ok := errorCode(err, "Number")
+	return ok && (n == 1213 || n == 1205)
+}
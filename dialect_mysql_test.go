@@ -136,6 +136,42 @@ func TestMySQLDialect(t *testing.T) {
 		tt.expect(tt.dialect.BindVar(0)).To(matchers.Equal("?"))
 	})
 
+	o.Spec("MaxBindVars", func(tt testContext) {
+		tt.expect(tt.dialect.MaxBindVars()).To(matchers.Equal(65535))
+	})
+
+	o.Group("UpsertSql", func() {
+		o.Spec("updating on conflict", func(tt testContext) {
+			sql := tt.dialect.UpsertSql("`foo`", []string{"id", "bar"}, []string{"?", "?"}, []string{"id"}, []string{"bar"}, "")
+			tt.expect(sql).To(matchers.Equal("insert into `foo` (`id`,`bar`) values (?,?) on duplicate key update `bar`=values(`bar`);"))
+		})
+
+		o.Spec("with a version column", func(tt testContext) {
+			sql := tt.dialect.UpsertSql("`foo`", []string{"id", "bar", "ver"}, []string{"?", "?", "?"}, []string{"id"}, []string{"bar", "ver"}, "ver")
+			tt.expect(sql).To(matchers.Equal("insert into `foo` (`id`,`bar`,`ver`) values (?,?,?) on duplicate key update " +
+				"`bar`=if(`ver`=values(`ver`)-1,values(`bar`),`bar`), `ver`=if(`ver`=values(`ver`)-1,values(`ver`),`ver`);"))
+		})
+
+		o.Spec("without columns to update", func(tt testContext) {
+			sql := tt.dialect.UpsertSql("`foo`", []string{"id"}, []string{"?"}, []string{"id"}, nil, "")
+			tt.expect(sql).To(matchers.Equal("insert into `foo` (`id`) values (?) on duplicate key update `id`=`id`;"))
+		})
+	})
+
+	o.Group("PageSql", func() {
+		o.Spec("with a limit and offset", func(tt testContext) {
+			tt.expect(tt.dialect.PageSql("select * from foo", 10, 20)).To(matchers.Equal("select * from foo limit 10 offset 20"))
+		})
+
+		o.Spec("with an offset only", func(tt testContext) {
+			tt.expect(tt.dialect.PageSql("select * from foo", -1, 20)).To(matchers.Equal("select * from foo limit 18446744073709551615 offset 20"))
+		})
+	})
+
+	o.Spec("CountSql", func(tt testContext) {
+		tt.expect(tt.dialect.CountSql("select * from foo")).To(matchers.Equal("select count(*) from (select * from foo) as gorp_count"))
+	})
+
 	o.Spec("QuoteField", func(tt testContext) {
 		tt.expect(tt.dialect.QuoteField("foo")).To(matchers.Equal("`foo`"))
 	})
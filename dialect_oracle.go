@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 )
 
 // Implementation of Dialect for Oracle databases.
@@ -114,6 +115,39 @@ func (d OracleDialect) InsertQueryToTarget(exec SqlExecutor, insertSql, idSql st
 	return nil
 }
 
+// UpsertSql returns a "merge" statement.  Oracle does not support extra
+// conditions on "when matched", so the version check is written as a
+// where clause on the update.
+func (d OracleDialect) UpsertSql(table string, cols, vals, conflict, update []string, version string) string {
+	source := make([]string, len(cols))
+	for i, col := range cols {
+		source[i] = fmt.Sprintf("%s %s", vals[i], d.QuoteField(col))
+	}
+	return mergeUpsertSql(d, table, fmt.Sprintf("(select %s from dual) s", strings.Join(source, ",")),
+		cols, conflict, update, version, true)
+}
+
+func (d OracleDialect) AddColumnSql(table, def string) string {
+	return fmt.Sprintf("alter table %s add (%s)%s", table, def, d.QuerySuffix())
+}
+
+func (d OracleDialect) AlterColumnSql(table, column, sqlType string, notNull bool, defaultValue string) []string {
+	s := fmt.Sprintf("alter table %s modify (%s %s", table, d.QuoteField(column), sqlType)
+	if defaultValue != "" {
+		s += " default " + defaultValue
+	}
+	if notNull {
+		s += " not null)"
+	} else {
+		s += " null)"
+	}
+	return []string{s + d.QuerySuffix()}
+}
+
+func (d OracleDialect) DropColumnSql(table, column string) string {
+	return standardDropColumnSql(d, table, column)
+}
+
 func (d OracleDialect) QuoteField(f string) string {
 	return `"` + strings.ToUpper(f) + `"`
 }
@@ -137,3 +171,27 @@ func (d OracleDialect) IfTableExists(command, schema, table string) string {
 func (d OracleDialect) IfTableNotExists(command, schema, table string) string {
 	return fmt.Sprintf("%s if not exists", command)
 }
+
+// Oracle 12c and later page with "offset ... fetch next".
+func (d OracleDialect) PageSql(query string, limit, offset int) string {
+	return offsetFetchSql(query, limit, offset)
+}
+
+func (d OracleDialect) CountSql(query string) string {
+	return fmt.Sprintf("select count(*) from (%s) gorp_count", query)
+}
+
+// Oracle has no "on update" clause, so onUpdate is ignored.
+func (d OracleDialect) ForeignKeySql(column, refTable, refColumn, onDelete, onUpdate string) string {
+	s := fmt.Sprintf("foreign key (%s) references %s (%s)", column, refTable, refColumn)
+	if onDelete != "" {
+		s += " on delete " + onDelete
+	}
+	return s
+}
+
+// TimePrecision returns a microsecond, the default precision of timestamp
+// columns.
+func (d OracleDialect) TimePrecision() time.Duration {
+	return time.Microsecond
+}
@@ -5,8 +5,12 @@
 package gorp
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"hash/fnv"
 	"reflect"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -121,6 +125,99 @@ func (d PostgresDialect) InsertAutoIncrToTarget(exec SqlExecutor, insertSql stri
 	return rows.Err()
 }
 
+// InsertAutoIncrToTargets relies on "returning" yielding one row per
+// inserted row, in the order of the values list.
+func (d PostgresDialect) InsertAutoIncrToTargets(exec SqlExecutor, insertSql string, targets []interface{}, params ...interface{}) error {
+	return insertAutoIncrToTargets(exec, insertSql, targets, params...)
+}
+
+// Returns 65535, the limit of the Postgres wire protocol
+func (d PostgresDialect) MaxBindVars() int {
+	return 65535
+}
+
+func (d PostgresDialect) UpsertSql(table string, cols, vals, conflict, update []string, version string) string {
+	return onConflictUpsertSql(d, table, cols, vals, conflict, update, version)
+}
+
+// postgresCastRegexp matches the cast Postgres appends to literal column
+// defaults, as in 'foo'::character varying
+var postgresCastRegexp = regexp.MustCompile(`::[a-z ]+$`)
+
+func (d PostgresDialect) TableColumns(exec SqlExecutor, schema, table string) ([]CatalogColumn, error) {
+	if d.LowercaseFields {
+		table = strings.ToLower(table)
+	}
+	cols, err := informationSchemaColumns(d, exec, "current_schema()",
+		"case when character_maximum_length is not null then 'varchar(' || character_maximum_length || ')' else data_type end",
+		schema, table)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cols {
+		cols[i].Default = postgresCastRegexp.ReplaceAllString(cols[i].Default, "")
+	}
+	return cols, nil
+}
+
+func (d PostgresDialect) TableIndexes(exec SqlExecutor, schema, table string) ([]CatalogIndex, error) {
+	if d.LowercaseFields {
+		table = strings.ToLower(table)
+	}
+	rows, err := exec.Query(`select i.relname, ix.indisunique, a.attname,
+	exists (select 1 from pg_constraint c where c.conindid = ix.indexrelid)
+from pg_index ix
+	join pg_class t on t.oid = ix.indrelid
+	join pg_class i on i.oid = ix.indexrelid
+	join pg_namespace n on n.oid = t.relnamespace
+	join lateral unnest(ix.indkey) with ordinality as k(attnum, ord) on true
+	join pg_attribute a on a.attrelid = t.oid and a.attnum = k.attnum
+where n.nspname = coalesce(nullif($1, ''), current_schema()) and t.relname = $2 and not ix.indisprimary
+order by i.relname, k.ord`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []CatalogIndex
+	for rows.Next() {
+		var idx CatalogIndex
+		var col string
+		if err := rows.Scan(&idx.Name, &idx.Unique, &col, &idx.Constraint); err != nil {
+			return nil, err
+		}
+		if n := len(indexes); n > 0 && indexes[n-1].Name == idx.Name {
+			indexes[n-1].Columns = append(indexes[n-1].Columns, col)
+			continue
+		}
+		idx.Columns = []string{col}
+		indexes = append(indexes, idx)
+	}
+	return indexes, rows.Err()
+}
+
+func (d PostgresDialect) AddColumnSql(table, def string) string {
+	return standardAddColumnSql(d, table, def)
+}
+
+func (d PostgresDialect) AlterColumnSql(table, column, sqlType string, notNull bool, defaultValue string) []string {
+	col := d.QuoteField(column)
+	s := fmt.Sprintf("alter table %s alter column %s type %s", table, col, sqlType)
+	if notNull {
+		s += fmt.Sprintf(", alter column %s set not null", col)
+	} else {
+		s += fmt.Sprintf(", alter column %s drop not null", col)
+	}
+	if defaultValue != "" {
+		s += fmt.Sprintf(", alter column %s set default %s", col, defaultValue)
+	}
+	return []string{s + d.QuerySuffix()}
+}
+
+func (d PostgresDialect) DropColumnSql(table, column string) string {
+	return standardDropColumnSql(d, table, column)
+}
+
 func (d PostgresDialect) QuoteField(f string) string {
 	if d.LowercaseFields {
 		return `"` + strings.ToLower(f) + `"`
@@ -147,3 +244,34 @@ func (d PostgresDialect) IfTableExists(command, schema, table string) string {
 func (d PostgresDialect) IfTableNotExists(command, schema, table string) string {
 	return fmt.Sprintf("%s if not exists", command)
 }
+
+// AdvisoryLock takes a session level pg_advisory_lock keyed by a hash of
+// name.
+func (d PostgresDialect) AdvisoryLock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "select pg_advisory_lock($1)", postgresLockKey(name))
+	return err
+}
+
+func (d PostgresDialect) AdvisoryUnlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "select pg_advisory_unlock($1)", postgresLockKey(name))
+	return err
+}
+
+func postgresLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// TimePrecision returns a microsecond, the precision of timestamp columns.
+func (d PostgresDialect) TimePrecision() time.Duration {
+	return time.Microsecond
+}
+
+// IsRetryable reports whether err is a serialization failure (SQLSTATE
+// 40001) or a deadlock (40P01), for drivers whose errors have a
+// SQLState method, such as lib/pq and pgx.
+func (d PostgresDialect) IsRetryable(err error) bool {
+	state, ok := sqlState(err)
+	return ok && (state == "40001" || state == "40P01")
+}
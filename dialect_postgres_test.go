@@ -115,6 +115,27 @@ func TestPostgresDialect(t *testing.T) {
 		tt.expect(tt.dialect.BindVar(4)).To(matchers.Equal("$5"))
 	})
 
+	o.Spec("MaxBindVars", func(tt testContext) {
+		tt.expect(tt.dialect.MaxBindVars()).To(matchers.Equal(65535))
+	})
+
+	o.Group("UpsertSql", func() {
+		o.Spec("updating on conflict", func(tt testContext) {
+			sql := tt.dialect.UpsertSql(`"foo"`, []string{"id", "bar"}, []string{"$1", "$2"}, []string{"id"}, []string{"bar"}, "")
+			tt.expect(sql).To(matchers.Equal(`insert into "foo" ("id","bar") values ($1,$2) on conflict ("id") do update set "bar"=excluded."bar";`))
+		})
+
+		o.Spec("with a version column", func(tt testContext) {
+			sql := tt.dialect.UpsertSql(`"foo"`, []string{"id", "ver"}, []string{"$1", "$2"}, []string{"id"}, []string{"ver"}, "ver")
+			tt.expect(sql).To(matchers.Equal(`insert into "foo" ("id","ver") values ($1,$2) on conflict ("id") do update set "ver"=excluded."ver" where "foo"."ver"=excluded."ver"-1;`))
+		})
+
+		o.Spec("without columns to update", func(tt testContext) {
+			sql := tt.dialect.UpsertSql(`"foo"`, []string{"id"}, []string{"$1"}, []string{"id"}, nil, "")
+			tt.expect(sql).To(matchers.Equal(`insert into "foo" ("id") values ($1) on conflict ("id") do nothing;`))
+		})
+	})
+
 	o.Group("QuoteField", func() {
 		o.Spec("By default, case is preserved", func(tt testContext) {
 			tt.expect(tt.dialect.QuoteField("Foo")).To(matchers.Equal(`"Foo"`))
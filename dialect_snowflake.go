@@ -124,6 +124,45 @@ func (d SnowflakeDialect) InsertAutoIncrToTarget(exec SqlExecutor, insertSql str
   return rows.Err()
 }
 
+func (d SnowflakeDialect) InsertAutoIncrToTargets(exec SqlExecutor, insertSql string, targets []interface{}, params ...interface{}) error {
+  return insertAutoIncrToTargets(exec, insertSql, targets, params...)
+}
+
+func (d SnowflakeDialect) MaxBindVars() int {
+  return 65535
+}
+
+func (d SnowflakeDialect) UpsertSql(table string, cols, vals, conflict, update []string, version string) string {
+  source := make([]string, len(cols))
+  for i, col := range cols {
+    source[i] = fmt.Sprintf("%s as %s", vals[i], d.QuoteField(col))
+  }
+  return mergeUpsertSql(d, table, fmt.Sprintf("(select %s) s", strings.Join(source, ",")),
+    cols, conflict, update, version, false)
+}
+
+func (d SnowflakeDialect) AddColumnSql(table, def string) string {
+  return standardAddColumnSql(d, table, def)
+}
+
+// Snowflake only allows changing the default of sequence columns, so
+// defaultValue is not applied.
+func (d SnowflakeDialect) AlterColumnSql(table, column, sqlType string, notNull bool, defaultValue string) []string {
+  col := d.QuoteField(column)
+  null := "drop not null"
+  if notNull {
+    null = "set not null"
+  }
+  return []string{
+    fmt.Sprintf("alter table %s alter column %s set data type %s%s", table, col, sqlType, d.QuerySuffix()),
+    fmt.Sprintf("alter table %s alter column %s %s%s", table, col, null, d.QuerySuffix()),
+  }
+}
+
+func (d SnowflakeDialect) DropColumnSql(table, column string) string {
+  return standardDropColumnSql(d, table, column)
+}
+
 func (d SnowflakeDialect) QuoteField(f string) string {
   if d.LowercaseFields {
     return `"` + strings.ToLower(f) + `"`
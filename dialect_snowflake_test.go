@@ -107,6 +107,24 @@ func TestSnowflakeDialect(t *testing.T) {
 		tt.expect(tt.dialect.BindVar(4)).To(matchers.Equal("?"))
 	})
 
+	o.Spec("MaxBindVars", func(tt testContext) {
+		tt.expect(tt.dialect.MaxBindVars()).To(matchers.Equal(65535))
+	})
+
+	o.Group("UpsertSql", func() {
+		o.Spec("updating on conflict", func(tt testContext) {
+			sql := tt.dialect.UpsertSql(`"foo"`, []string{"id", "bar"}, []string{"?", "?"}, []string{"id"}, []string{"bar"}, "")
+			tt.expect(sql).To(matchers.Equal(`merge into "foo" t using (select ? as "id",? as "bar") s on (t."id"=s."id") ` +
+				`when matched then update set "bar"=s."bar" when not matched then insert ("id","bar") values (s."id",s."bar");`))
+		})
+
+		o.Spec("with a version column", func(tt testContext) {
+			sql := tt.dialect.UpsertSql(`"foo"`, []string{"id", "ver"}, []string{"?", "?"}, []string{"id"}, []string{"ver"}, "ver")
+			tt.expect(sql).To(matchers.Equal(`merge into "foo" t using (select ? as "id",? as "ver") s on (t."id"=s."id") ` +
+				`when matched and t."ver"=s."ver"-1 then update set "ver"=s."ver" when not matched then insert ("id","ver") values (s."id",s."ver");`))
+		})
+	})
+
 	o.Group("QuoteField", func() {
 		o.Spec("By default, case is preserved", func(tt testContext) {
 			tt.expect(tt.dialect.QuoteField("Foo")).To(matchers.Equal(`"Foo"`))
@@ -5,8 +5,11 @@
 package gorp
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"reflect"
+	"time"
 )
 
 type SqliteDialect struct {
@@ -90,6 +93,113 @@ func (d SqliteDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, params
 	return standardInsertAutoIncr(exec, insertSql, params...)
 }
 
+// InsertAutoIncrBatch relies on sqlite reporting the rowid of the last row
+// of a multi-row insert, with the rows of a single statement being
+// assigned consecutive rowids.
+func (d SqliteDialect) InsertAutoIncrBatch(exec SqlExecutor, insertSql string, rows int, params ...interface{}) ([]int64, error) {
+	last, err := standardInsertAutoIncr(exec, insertSql, params...)
+	if err != nil {
+		return nil, err
+	}
+	return consecutiveIds(last-int64(rows)+1, rows), nil
+}
+
+// Returns 999, the default SQLITE_MAX_VARIABLE_NUMBER of sqlite
+// versions prior to 3.32.0
+func (d SqliteDialect) MaxBindVars() int {
+	return 999
+}
+
+func (d SqliteDialect) UpsertSql(table string, cols, vals, conflict, update []string, version string) string {
+	return onConflictUpsertSql(d, table, cols, vals, conflict, update, version)
+}
+
+func (d SqliteDialect) TableColumns(exec SqlExecutor, schema, table string) ([]CatalogColumn, error) {
+	rows, err := exec.Query(`select name, type, "notnull", dflt_value from pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []CatalogColumn
+	for rows.Next() {
+		var col CatalogColumn
+		var def sql.NullString
+		if err := rows.Scan(&col.Name, &col.Type, &col.NotNull, &def); err != nil {
+			return nil, err
+		}
+		col.Default = def.String
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+func (d SqliteDialect) TableIndexes(exec SqlExecutor, schema, table string) ([]CatalogIndex, error) {
+	rows, err := exec.Query(`select name, "unique", origin from pragma_index_list(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []CatalogIndex
+	for rows.Next() {
+		var idx CatalogIndex
+		var origin string
+		if err := rows.Scan(&idx.Name, &idx.Unique, &origin); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		// origin is "pk" for the primary key, "u" for unique constraints
+		// and "c" for indexes made by "create index"
+		if origin == "pk" {
+			continue
+		}
+		idx.Constraint = origin == "u"
+		indexes = append(indexes, idx)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range indexes {
+		indexes[i].Columns, err = d.indexColumns(exec, indexes[i].Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return indexes, nil
+}
+
+func (d SqliteDialect) indexColumns(exec SqlExecutor, index string) ([]string, error) {
+	rows, err := exec.Query(`select name from pragma_index_info(?) order by seqno`, index)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+func (d SqliteDialect) AddColumnSql(table, def string) string {
+	return standardAddColumnSql(d, table, def)
+}
+
+// sqlite cannot alter existing columns, so this returns nil
+func (d SqliteDialect) AlterColumnSql(table, column, sqlType string, notNull bool, defaultValue string) []string {
+	return nil
+}
+
+func (d SqliteDialect) DropColumnSql(table, column string) string {
+	return standardDropColumnSql(d, table, column)
+}
+
 func (d SqliteDialect) QuoteField(f string) string {
 	return `"` + f + `"`
 }
@@ -110,3 +220,63 @@ func (d SqliteDialect) IfTableExists(command, schema, table string) string {
 func (d SqliteDialect) IfTableNotExists(command, schema, table string) string {
 	return fmt.Sprintf("%s if not exists", command)
 }
+
+// SqliteLocksTable is the table holding the rows of advisory locks taken
+// by SqliteDialect.
+const SqliteLocksTable = "gorp_locks"
+
+// sqliteLockPoll is how often AdvisoryLock retries while the lock is held
+const sqliteLockPoll = 100 * time.Millisecond
+
+// SQLite has no advisory locks, so AdvisoryLock inserts a row named after
+// the lock into the SqliteLocksTable, retrying until the row can be
+// inserted.  AdvisoryUnlock deletes the row.  A lock left behind by a
+// crashed process must be deleted by hand.
+func (d SqliteDialect) AdvisoryLock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("create table if not exists %s (name varchar(255) not null primary key, locked_at datetime not null);",
+		d.QuoteField(SqliteLocksTable)))
+	if err != nil {
+		return err
+	}
+	insert := fmt.Sprintf("insert or ignore into %s (name, locked_at) values (?, ?);", d.QuoteField(SqliteLocksTable))
+	for {
+		res, err := conn.ExecContext(ctx, insert, name, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 1 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sqliteLockPoll):
+		}
+	}
+}
+
+func (d SqliteDialect) AdvisoryUnlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("delete from %s where name = ?;", d.QuoteField(SqliteLocksTable)), name)
+	return err
+}
+
+// sqlite requires a limit before an offset; -1 means no limit.
+func (d SqliteDialect) PageSql(query string, limit, offset int) string {
+	query += fmt.Sprintf(" limit %d", limit)
+	if offset > 0 {
+		query += fmt.Sprintf(" offset %d", offset)
+	}
+	return query
+}
+
+func (d SqliteDialect) CountSql(query string) string {
+	return fmt.Sprintf("select count(*) from (%s)", query)
+}
+
+// IsRetryable reports whether err reports the database as busy or
+// locked (SQLITE_BUSY or SQLITE_LOCKED), for drivers whose errors hold
+// the result code in a Code field, such as mattn/go-sqlite3.
+func (d SqliteDialect) IsRetryable(err error) bool {
+	n, ok := errorCode(err, "Code")
+	return ok && (n == 5 || n == 6)
+}
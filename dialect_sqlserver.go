@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 )
 
 // Implementation of Dialect for Microsoft SQL Server databases.
@@ -105,6 +106,29 @@ func (d SqlServerDialect) InsertAutoIncr(exec SqlExecutor, insertSql string, par
 	return standardInsertAutoIncr(exec, insertSql, params...)
 }
 
+func (d SqlServerDialect) UpsertSql(table string, cols, vals, conflict, update []string, version string) string {
+	source := fmt.Sprintf("(values (%s)) as s (%s)", strings.Join(vals, ","), quoteFields(d, cols))
+	return mergeUpsertSql(d, table, source, cols, conflict, update, version, false)
+}
+
+func (d SqlServerDialect) AddColumnSql(table, def string) string {
+	return fmt.Sprintf("alter table %s add %s%s", table, def, d.QuerySuffix())
+}
+
+// Column defaults are constraints in SQL Server, so defaultValue is not
+// applied.
+func (d SqlServerDialect) AlterColumnSql(table, column, sqlType string, notNull bool, defaultValue string) []string {
+	null := "null"
+	if notNull {
+		null = "not null"
+	}
+	return []string{fmt.Sprintf("alter table %s alter column %s %s %s%s", table, d.QuoteField(column), sqlType, null, d.QuerySuffix())}
+}
+
+func (d SqlServerDialect) DropColumnSql(table, column string) string {
+	return standardDropColumnSql(d, table, column)
+}
+
 func (d SqlServerDialect) QuoteField(f string) string {
 	return "[" + strings.Replace(f, "]", "]]", -1) + "]"
 }
@@ -143,3 +167,21 @@ func (d SqlServerDialect) IfTableNotExists(command, schema, table string) string
 
 func (d SqlServerDialect) CreateIndexSuffix() string { return "" }
 func (d SqlServerDialect) DropIndexSuffix() string   { return "" }
+
+// SQL Server pages with "offset ... fetch next", which requires query to
+// have an order by clause.
+func (d SqlServerDialect) PageSql(query string, limit, offset int) string {
+	return offsetFetchSql(query, limit, offset)
+}
+
+// The order by clause of query is only allowed in a derived table
+// together with an offset, so "offset 0 rows" is added to it.
+func (d SqlServerDialect) CountSql(query string) string {
+	return fmt.Sprintf("select count(*) from (%s offset 0 rows) as gorp_count", query)
+}
+
+// TimePrecision returns 100 nanoseconds, the precision of datetime2
+// columns.
+func (d SqlServerDialect) TimePrecision() time.Duration {
+	return 100 * time.Nanosecond
+}
@@ -0,0 +1,45 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+// GetT has the same behavior as SqlExecutor.Get, but returns the row as a
+// *T, or nil if no row is found.  T must be a struct mapped with AddTable
+// or AddTableWithName.
+//
+// Example:
+//
+//	inv, err := gorp.GetT[Invoice](dbmap, id)
+func GetT[T any](exec SqlExecutor, keys ...interface{}) (*T, error) {
+	obj, err := exec.Get(new(T), keys...)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return obj.(*T), nil
+}
+
+// SelectT has the same behavior as SqlExecutor.Select, but returns the
+// rows as a []T.  T is a struct, a pointer to a struct, or a single
+// column type such as int64 or string.
+//
+// Example:
+//
+//	invoices, err := gorp.SelectT[Invoice](tx, "select * from invoice_test where PersonId=?", personId)
+func SelectT[T any](exec SqlExecutor, query string, args ...interface{}) ([]T, error) {
+	var list []T
+	_, err := exec.Select(&list, query, args...)
+	if err != nil && !NonFatalError(err) {
+		return nil, err
+	}
+	return list, err
+}
+
+// SelectOneT has the same behavior as SqlExecutor.SelectOne, but returns
+// the row as a T.  As with SelectOne, it returns sql.ErrNoRows if the
+// query returns no rows.
+func SelectOneT[T any](exec SqlExecutor, query string, args ...interface{}) (T, error) {
+	var holder T
+	err := exec.SelectOne(&holder, query, args...)
+	return holder, err
+}
@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -121,6 +122,9 @@ type DynamicTable interface {
 var _, _ SqlExecutor = &DbMap{}, &Transaction{}
 
 func argValue(a interface{}) interface{} {
+	if _, ok := a.(SensitiveValue); ok {
+		return a
+	}
 	v, ok := a.(driver.Valuer)
 	if !ok {
 		return a
@@ -141,6 +145,8 @@ func argsString(args ...interface{}) string {
 	for i, a := range args {
 		v := argValue(a)
 		switch v.(type) {
+		case SensitiveValue:
+			v = redacted
 		case string:
 			v = fmt.Sprintf("%q", v)
 		default:
@@ -401,7 +407,12 @@ func get(m *DbMap, exec SqlExecutor, i interface{},
 	}
 	table := foundTable.table
 
-	plan := table.bindGet()
+	tenantArgs, err := table.tenantArgs(exec)
+	if err != nil {
+		return nil, err
+	}
+	live := table.filtered(exec)
+	plan := table.bindGet(live)
 
 	v := reflect.New(t)
 	if foundTable.dynName != nil {
@@ -409,6 +420,21 @@ func get(m *DbMap, exec SqlExecutor, i interface{},
 		retDyn.SetTableName(*foundTable.dynName)
 	}
 
+	cacheKey := ""
+	var cacheGen uint64
+	if m.usesCache(table, exec) {
+		key := table.cacheKey(keys...)
+		cacheGen = atomic.LoadUint64(cacheGeneration(key))
+		if cached, ok := m.Cache.Get(key); ok {
+			v.Elem().Set(table.copyRow(reflect.ValueOf(cached)))
+			return finishGet(exec, table, v)
+		}
+		// a replica may return a row older than the cached one it replaces
+		if !readsReplica(exec) {
+			cacheKey = key
+		}
+	}
+
 	dest := make([]interface{}, len(plan.argFields))
 
 	conv := m.TypeConverter
@@ -427,7 +453,12 @@ func get(m *DbMap, exec SqlExecutor, i interface{},
 		dest[x] = target
 	}
 
-	row := exec.QueryRow(plan.query, keys...)
+	if live {
+		_, args := table.softDeleteCond(false)
+		keys = append(keys[:len(keys):len(keys)], args...)
+	}
+	keys = append(keys[:len(keys):len(keys)], tenantArgs...)
+	row := withCall(exec, table, "Get").QueryRow(plan.query, keys...)
 	err = row.Scan(dest...)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -442,6 +473,16 @@ func get(m *DbMap, exec SqlExecutor, i interface{},
 			return nil, err
 		}
 	}
+	if cacheKey != "" {
+		m.cacheRow(table, cacheKey, cacheGen, v.Elem())
+	}
+
+	return finishGet(exec, table, v)
+}
+
+// finishGet snapshots the row v fetched by get and calls its PostGet hook.
+func finishGet(exec SqlExecutor, table *TableMap, v reflect.Value) (interface{}, error) {
+	table.takeSnapshot(v.Elem())
 
 	if v, ok := v.Interface().(HasPostGet); ok {
 		err := v.PostGet(exec)
@@ -453,7 +494,9 @@ func get(m *DbMap, exec SqlExecutor, i interface{},
 	return v.Interface(), nil
 }
 
-func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
+// deleteRows deletes the rows of list, or marks them as deleted if their
+// table has a soft delete column and hard is false.
+func deleteRows(m *DbMap, exec SqlExecutor, hard bool, list ...interface{}) (int64, error) {
 	count := int64(0)
 	for _, ptr := range list {
 		table, elem, err := m.tableForPointer(ptr, true)
@@ -469,12 +512,23 @@ func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 			}
 		}
 
-		bi, err := table.bindDelete(elem)
+		var bi bindInstance
+		var softValue reflect.Value
+		if table.softDelete != nil && !hard {
+			bi, softValue, err = table.bindSoftDelete(elem, true)
+		} else {
+			bi, err = table.bindDelete(elem)
+		}
+		if err != nil {
+			return -1, err
+		}
+		tenantArgs, err := table.tenantArgs(exec)
 		if err != nil {
 			return -1, err
 		}
+		bi.args = append(bi.args, tenantArgs...)
 
-		res, err := exec.Exec(bi.query, bi.args...)
+		res, err := withCall(exec, table, "Delete").Exec(bi.query, bi.args...)
 		if err != nil {
 			return -1, err
 		}
@@ -487,6 +541,13 @@ func delete(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
 			return lockError(m, exec, table.TableName,
 				bi.existingVersion, elem, bi.keys...)
 		}
+		if rows > 0 && softValue.IsValid() {
+			elem.FieldByName(table.softDelete.fieldName).Set(softValue)
+			if bi.versField != "" {
+				elem.FieldByName(bi.versField).SetInt(bi.existingVersion + 1)
+			}
+		}
+		m.uncache(exec, table, elem)
 
 		count += rows
 
@@ -517,48 +578,165 @@ func update(m *DbMap, exec SqlExecutor, colFilter ColumnFilter, list ...interfac
 			}
 		}
 
-		bi, err := table.bindUpdate(elem, colFilter)
+		rows, err := updateRow(m, exec, table, elem, colFilter)
 		if err != nil {
 			return -1, err
 		}
+		count += rows
 
-		res, err := exec.Exec(bi.query, bi.args...)
-		if err != nil {
-			return -1, err
+		if v, ok := eval.(HasPostUpdate); ok {
+			err = v.PostUpdate(exec)
+			if err != nil {
+				return -1, err
+			}
 		}
+	}
+	return count, nil
+}
 
-		rows, err := res.RowsAffected()
+// updateRow updates the row elem of table and returns the number of rows
+// updated, which is 0 if elem has a Snapshot and none of its columns
+// changed: no statement is run then.
+func updateRow(m *DbMap, exec SqlExecutor, table *TableMap, elem reflect.Value, colFilter ColumnFilter) (int64, error) {
+	filter := colFilter
+	if changed, ok := table.changedColumns(elem, colFilter); ok {
+		if len(changed) == 0 {
+			return 0, nil
+		}
+		filter = func(col *ColumnMap) bool {
+			return changed[col] || col.isUpdateTime
+		}
+	}
+
+	bi, err := table.bindUpdate(elem, filter)
+	if err != nil {
+		return -1, err
+	}
+	tenantArgs, err := table.tenantArgs(exec)
+	if err != nil {
+		return -1, err
+	}
+	bi.args = append(bi.args, tenantArgs...)
+
+	res, err := withCall(exec, table, "Update").Exec(bi.query, bi.args...)
+	if err != nil {
+		return -1, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+
+	if rows == 0 && bi.existingVersion > 0 {
+		return lockError(m, exec, table.TableName,
+			bi.existingVersion, elem, bi.keys...)
+	}
+
+	if bi.versField != "" {
+		elem.FieldByName(bi.versField).SetInt(bi.existingVersion + 1)
+	}
+	table.takeSnapshot(elem)
+	m.uncache(exec, table, elem)
+	return rows, nil
+}
+
+func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
+	for _, ptr := range list {
+		table, elem, err := m.tableForPointer(ptr, false)
 		if err != nil {
-			return -1, err
+			return err
 		}
 
-		if rows == 0 && bi.existingVersion > 0 {
-			return lockError(m, exec, table.TableName,
-				bi.existingVersion, elem, bi.keys...)
+		eval := elem.Addr().Interface()
+		if v, ok := eval.(HasPreInsert); ok {
+			err := v.PreInsert(exec)
+			if err != nil {
+				return err
+			}
 		}
 
-		if bi.versField != "" {
-			elem.FieldByName(bi.versField).SetInt(bi.existingVersion + 1)
+		if err := insertRow(m, exec, table, elem, "Insert"); err != nil {
+			return err
 		}
 
-		count += rows
+		table.takeSnapshot(elem)
 
-		if v, ok := eval.(HasPostUpdate); ok {
-			err = v.PostUpdate(exec)
+		if v, ok := eval.(HasPostInsert); ok {
+			err := v.PostInsert(exec)
 			if err != nil {
-				return -1, err
+				return err
 			}
 		}
 	}
-	return count, nil
+	return nil
 }
 
-func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
+// insertRow inserts the row elem of table, setting its auto-increment key
+// to the one generated by the database.  method is the gorp method
+// reported to interceptors.
+func insertRow(m *DbMap, exec SqlExecutor, table *TableMap, elem reflect.Value, method string) error {
+	if err := table.stampTenant(exec, elem); err != nil {
+		return err
+	}
+	bi, err := table.bindInsert(elem)
+	if err != nil {
+		return err
+	}
+
+	if bi.autoIncrIdx > -1 {
+		// the generated id, which some dialects select once the row is
+		// inserted, can only be read from the primary
+		f := elem.FieldByName(bi.autoIncrFieldName)
+		switch inserter := m.Dialect.(type) {
+		case IntegerAutoIncrInserter:
+			id, err := inserter.InsertAutoIncr(withCall(onPrimary(exec), table, method), bi.query, bi.args...)
+			if err != nil {
+				return err
+			}
+			if !setAutoIncrValue(f, id) {
+				return fmt.Errorf("gorp: cannot set autoincrement value on non-Int field. SQL=%s  autoIncrIdx=%d autoIncrFieldName=%s", bi.query, bi.autoIncrIdx, bi.autoIncrFieldName)
+			}
+		case TargetedAutoIncrInserter:
+			err := inserter.InsertAutoIncrToTarget(withCall(onPrimary(exec), table, method), bi.query, f.Addr().Interface(), bi.args...)
+			if err != nil {
+				return err
+			}
+		case TargetQueryInserter:
+			var idQuery = table.ColMap(bi.autoIncrFieldName).GeneratedIdQuery
+			if idQuery == "" {
+				return fmt.Errorf("gorp: cannot set %s value if its ColumnMap.GeneratedIdQuery is empty", bi.autoIncrFieldName)
+			}
+			err := inserter.InsertQueryToTarget(withCall(onPrimary(exec), table, method), bi.query, idQuery, f.Addr().Interface(), bi.args...)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("gorp: cannot use autoincrement fields on dialects that do not implement an autoincrementing interface")
+		}
+	} else {
+		_, err := withCall(exec, table, method).Exec(bi.query, bi.args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func upsert(m *DbMap, exec SqlExecutor, conflict string, colFilter ColumnFilter, list ...interface{}) error {
+	upserter, ok := m.Dialect.(Upserter)
+	if !ok {
+		return fmt.Errorf("gorp: dialect %T does not support upserts", m.Dialect)
+	}
+
 	for _, ptr := range list {
 		table, elem, err := m.tableForPointer(ptr, false)
 		if err != nil {
 			return err
 		}
+		if table.tenant != nil {
+			return fmt.Errorf("gorp: table %s is scoped by tenant and does not support upserts", table.TableName)
+		}
 
 		eval := elem.Addr().Interface()
 		if v, ok := eval.(HasPreInsert); ok {
@@ -568,52 +746,198 @@ func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
 			}
 		}
 
-		bi, err := table.bindInsert(elem)
+		if table.hasNewAutoIncrKey(elem, conflict) {
+			// the row cannot conflict with an existing one: insert it
+			// with a generated key
+			if err := insertRow(m, exec, table, elem, "Upsert"); err != nil {
+				return err
+			}
+		} else if err := upsertRow(exec, table, elem, upserter, conflict, colFilter); err != nil {
+			return err
+		}
+
+		table.takeSnapshot(elem)
+		m.uncache(exec, table, elem)
+
+		if v, ok := eval.(HasPostInsert); ok {
+			err := v.PostInsert(exec)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// upsertRow runs the upsert statement of the row elem of table.
+func upsertRow(exec SqlExecutor, table *TableMap, elem reflect.Value, upserter Upserter, conflict string, colFilter ColumnFilter) error {
+	bi, err := table.bindUpsert(elem, upserter, conflict, colFilter)
+	if err != nil {
+		return err
+	}
+
+	res, err := withCall(exec, table, "Upsert").Exec(bi.query, bi.args...)
+	if err != nil {
+		return err
+	}
+
+	if bi.versField != "" {
+		rows, err := res.RowsAffected()
 		if err != nil {
 			return err
 		}
+		// Nothing is written only if the row conflicted with an
+		// existing row of a different version.
+		if rows == 0 {
+			return OptimisticLockError{table.TableName, bi.keys, true, bi.existingVersion}
+		}
+		elem.FieldByName(bi.versField).SetInt(bi.existingVersion + 1)
+	}
+	return nil
+}
 
-		if bi.autoIncrIdx > -1 {
-			f := elem.FieldByName(bi.autoIncrFieldName)
-			switch inserter := m.Dialect.(type) {
-			case IntegerAutoIncrInserter:
-				id, err := inserter.InsertAutoIncr(exec, bi.query, bi.args...)
-				if err != nil {
-					return err
-				}
-				k := f.Kind()
-				if (k == reflect.Int) || (k == reflect.Int16) || (k == reflect.Int32) || (k == reflect.Int64) {
-					f.SetInt(id)
-				} else if (k == reflect.Uint) || (k == reflect.Uint16) || (k == reflect.Uint32) || (k == reflect.Uint64) {
-					f.SetUint(uint64(id))
-				} else {
+// setAutoIncrValue assigns a generated integer key to f.  It returns false
+// if f is not an integer field.
+func setAutoIncrValue(f reflect.Value, id int64) bool {
+	k := f.Kind()
+	if (k == reflect.Int) || (k == reflect.Int16) || (k == reflect.Int32) || (k == reflect.Int64) {
+		f.SetInt(id)
+	} else if (k == reflect.Uint) || (k == reflect.Uint16) || (k == reflect.Uint32) || (k == reflect.Uint64) {
+		f.SetUint(uint64(id))
+	} else {
+		return false
+	}
+	return true
+}
+
+type insertGroup struct {
+	table *TableMap
+	elems []reflect.Value
+}
+
+func insertBatch(m *DbMap, exec SqlExecutor, list ...interface{}) error {
+	// Group the elements by table, keeping the tables in the order they
+	// first appear in list.
+	var groups []*insertGroup
+	byTable := make(map[*TableMap]*insertGroup)
+	for _, ptr := range list {
+		table, elem, err := m.tableForPointer(ptr, false)
+		if err != nil {
+			return err
+		}
+		g, ok := byTable[table]
+		if !ok {
+			g = &insertGroup{table: table}
+			byTable[table] = g
+			groups = append(groups, g)
+		}
+		g.elems = append(g.elems, elem)
+	}
+
+	for _, g := range groups {
+		if !m.canInsertBatch(g.table) {
+			ptrs := make([]interface{}, len(g.elems))
+			for i, elem := range g.elems {
+				ptrs[i] = elem.Addr().Interface()
+			}
+			if err := insert(m, exec, ptrs...); err != nil {
+				return err
+			}
+			continue
+		}
+
+		plan := g.table.bindInsertPlan()
+		rows := m.Dialect.(BatchInserter).MaxBindVars() / len(plan.argFields)
+		if rows < 1 {
+			rows = 1
+		}
+		for start := 0; start < len(g.elems); start += rows {
+			end := start + rows
+			if end > len(g.elems) {
+				end = len(g.elems)
+			}
+			if err := insertChunk(m, exec, g.table, g.elems[start:end]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// canInsertBatch reports whether rows of table can be inserted with
+// multi-row statements, which requires the dialect to support them and,
+// for tables with an auto-increment key, to be able to return the
+// generated keys of every row.
+func (m *DbMap) canInsertBatch(table *TableMap) bool {
+	if _, ok := m.Dialect.(BatchInserter); !ok {
+		return false
+	}
+	plan := table.bindInsertPlan()
+	if len(plan.argFields) == 0 {
+		return false
+	}
+	if plan.autoIncrIdx > -1 {
+		switch m.Dialect.(type) {
+		case IntegerAutoIncrBatchInserter, TargetedAutoIncrBatchInserter:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func insertChunk(m *DbMap, exec SqlExecutor, table *TableMap, elems []reflect.Value) error {
+	for _, elem := range elems {
+		if v, ok := elem.Addr().Interface().(HasPreInsert); ok {
+			err := v.PreInsert(exec)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, elem := range elems {
+		if err := table.stampTenant(exec, elem); err != nil {
+			return err
+		}
+	}
+	bi, err := table.bindInsertBatch(elems)
+	if err != nil {
+		return err
+	}
+
+	if bi.autoIncrIdx > -1 {
+		switch inserter := m.Dialect.(type) {
+		case IntegerAutoIncrBatchInserter:
+			ids, err := inserter.InsertAutoIncrBatch(withCall(onPrimary(exec), table, "InsertBatch"), bi.query, len(elems), bi.args...)
+			if err != nil {
+				return err
+			}
+			for i, elem := range elems {
+				if !setAutoIncrValue(elem.FieldByName(bi.autoIncrFieldName), ids[i]) {
 					return fmt.Errorf("gorp: cannot set autoincrement value on non-Int field. SQL=%s  autoIncrIdx=%d autoIncrFieldName=%s", bi.query, bi.autoIncrIdx, bi.autoIncrFieldName)
 				}
-			case TargetedAutoIncrInserter:
-				err := inserter.InsertAutoIncrToTarget(exec, bi.query, f.Addr().Interface(), bi.args...)
-				if err != nil {
-					return err
-				}
-			case TargetQueryInserter:
-				var idQuery = table.ColMap(bi.autoIncrFieldName).GeneratedIdQuery
-				if idQuery == "" {
-					return fmt.Errorf("gorp: cannot set %s value if its ColumnMap.GeneratedIdQuery is empty", bi.autoIncrFieldName)
-				}
-				err := inserter.InsertQueryToTarget(exec, bi.query, idQuery, f.Addr().Interface(), bi.args...)
-				if err != nil {
-					return err
-				}
-			default:
-				return fmt.Errorf("gorp: cannot use autoincrement fields on dialects that do not implement an autoincrementing interface")
 			}
-		} else {
-			_, err := exec.Exec(bi.query, bi.args...)
+		case TargetedAutoIncrBatchInserter:
+			targets := make([]interface{}, len(elems))
+			for i, elem := range elems {
+				targets[i] = elem.FieldByName(bi.autoIncrFieldName).Addr().Interface()
+			}
+			err := inserter.InsertAutoIncrToTargets(withCall(onPrimary(exec), table, "InsertBatch"), bi.query, targets, bi.args...)
 			if err != nil {
 				return err
 			}
 		}
+	} else {
+		_, err := withCall(exec, table, "InsertBatch").Exec(bi.query, bi.args...)
+		if err != nil {
+			return err
+		}
+	}
 
-		if v, ok := eval.(HasPostInsert); ok {
+	for _, elem := range elems {
+		table.takeSnapshot(elem)
+		if v, ok := elem.Addr().Interface().(HasPostInsert); ok {
 			err := v.PostInsert(exec)
 			if err != nil {
 				return err
@@ -626,47 +950,146 @@ func insert(m *DbMap, exec SqlExecutor, list ...interface{}) error {
 func exec(e SqlExecutor, query string, args ...interface{}) (sql.Result, error) {
 	executor, ctx := extractExecutorAndContext(e)
 
-	if ctx != nil {
-		return executor.ExecContext(ctx, query, args...)
+	var res sql.Result
+	m, ev := newEvent(e, OpExec, query, args)
+	err := m.intercept(ev, func(ev *QueryEvent) error {
+		var err error
+		if ctx != nil {
+			res, err = executor.ExecContext(ctx, ev.Query, unwrapArgs(ev.Args)...)
+		} else {
+			res, err = executor.Exec(ev.Query, unwrapArgs(ev.Args)...)
+		}
+		if err == nil && m.observed() {
+			if n, rerr := res.RowsAffected(); rerr == nil {
+				ev.RowsAffected = n
+			}
+		}
+		return err
+	})
+	if err == nil && res == nil {
+		err = errNoResult
 	}
-
-	return executor.Exec(query, args...)
+	return res, err
 }
 
 func prepare(e SqlExecutor, query string) (*sql.Stmt, error) {
 	executor, ctx := extractExecutorAndContext(e)
 
-	if ctx != nil {
-		return executor.PrepareContext(ctx, query)
+	var stmt *sql.Stmt
+	m, ev := newEvent(e, OpPrepare, query, nil)
+	err := m.intercept(ev, func(ev *QueryEvent) error {
+		var err error
+		if ctx != nil {
+			stmt, err = executor.PrepareContext(ctx, ev.Query)
+		} else {
+			stmt, err = executor.Prepare(ev.Query)
+		}
+		return err
+	})
+	if err == nil && stmt == nil {
+		err = errNoResult
 	}
-
-	return executor.Prepare(query)
+	return stmt, err
 }
 
 func queryRow(e SqlExecutor, query string, args ...interface{}) *sql.Row {
 	executor, ctx := extractExecutorAndContext(e)
-
-	if ctx != nil {
-		return executor.QueryRowContext(ctx, query, args...)
+	if m, ok := e.(*DbMap); ok {
+		executor = m.reader(query)
 	}
 
-	return executor.QueryRow(query, args...)
+	var row *sql.Row
+	m, ev := newEvent(e, OpQueryRow, query, args)
+	m.intercept(ev, func(ev *QueryEvent) error {
+		if ctx != nil {
+			row = executor.QueryRowContext(ctx, ev.Query, unwrapArgs(ev.Args)...)
+		} else {
+			row = executor.QueryRow(ev.Query, unwrapArgs(ev.Args)...)
+		}
+		return row.Err()
+	})
+	if row == nil {
+		return interceptedRow(executor, query, unwrapArgs(args)...)
+	}
+	return row
 }
 
 func query(e SqlExecutor, query string, args ...interface{}) (*sql.Rows, error) {
 	executor, ctx := extractExecutorAndContext(e)
-
-	if ctx != nil {
-		return executor.QueryContext(ctx, query, args...)
+	if m, ok := e.(*DbMap); ok {
+		executor = m.reader(query)
 	}
 
-	return executor.Query(query, args...)
+	var rows *sql.Rows
+	m, ev := newEvent(e, OpQuery, query, args)
+	err := m.intercept(ev, func(ev *QueryEvent) error {
+		var err error
+		if ctx != nil {
+			rows, err = executor.QueryContext(ctx, ev.Query, unwrapArgs(ev.Args)...)
+		} else {
+			rows, err = executor.Query(ev.Query, unwrapArgs(ev.Args)...)
+		}
+		return err
+	})
+	if err != nil {
+		if rows != nil {
+			rows.Close()
+		}
+		return nil, err
+	}
+	if rows == nil {
+		return nil, errNoResult
+	}
+	return rows, nil
 }
 
-func begin(m *DbMap) (*sql.Tx, error) {
-	if m.ctx != nil {
-		return m.Db.BeginTx(m.ctx, nil)
+// beginQuery returns the Query of the OpBegin event of a transaction with
+// the options opts.
+func beginQuery(opts *sql.TxOptions) string {
+	if opts == nil {
+		return "begin"
+	}
+	query := "begin"
+	if opts.Isolation != sql.LevelDefault {
+		query += " isolation level " + strings.ToLower(opts.Isolation.String())
 	}
+	if opts.ReadOnly {
+		query += " read only"
+	}
+	return query
+}
 
-	return m.Db.Begin()
+// begin starts a database transaction with the options opts, which may
+// be nil.  It also returns the context the Transaction runs with: the
+// one of m, unless an interceptor replaced it.
+func begin(m *DbMap, opts *sql.TxOptions) (*sql.Tx, context.Context, error) {
+	var tx *sql.Tx
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ev := &QueryEvent{Op: OpBegin, Context: ctx, Query: beginQuery(opts), TxOptions: opts}
+	err := m.intercept(ev, func(ev *QueryEvent) error {
+		var err error
+		if m.ctx != nil || opts != nil {
+			tx, err = m.Db.BeginTx(ctx, opts)
+		} else {
+			tx, err = m.Db.Begin()
+		}
+		ev.Tx = tx
+		return err
+	})
+	if err != nil {
+		if tx != nil {
+			tx.Rollback()
+		}
+		return nil, nil, err
+	}
+	if tx == nil {
+		return nil, nil, errNoResult
+	}
+	if ev.Context != ctx {
+		return tx, ev.Context, nil
+	}
+	return tx, m.ctx, nil
 }
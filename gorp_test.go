@@ -9,6 +9,7 @@ package gorp_test
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
@@ -21,7 +22,9 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/go-gorp/gorp/v3"
@@ -857,6 +860,2029 @@ func TestSetUniqueTogetherIdempotent(t *testing.T) {
 	}
 }
 
+func TestUpsert(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+
+	p1 := &Person{100, 0, 0, "bob", "smith", 0}
+	err := dbmap.Upsert(p1)
+	if err != nil {
+		panic(err)
+	}
+	if p1.Version != 1 {
+		t.Errorf("Upsert didn't incr Version: %d != %d", 1, p1.Version)
+	}
+	if p1.LName != "postinsert" {
+		t.Errorf("p1.PostInsert() didn't run: %v", p1)
+	}
+
+	p1.FName = "robert"
+	err = dbmap.Upsert(p1)
+	if err != nil {
+		panic(err)
+	}
+	if p1.Version != 2 {
+		t.Errorf("Upsert didn't incr Version: %d != %d", 2, p1.Version)
+	}
+	obj := _get(dbmap, Person{}, p1.Id)
+	if p2 := obj.(*Person); p2.FName != "robert" || p2.Version != 2 {
+		t.Errorf("Upsert didn't update existing row: %v", p2)
+	}
+	count := selectInt(dbmap, "select count(*) from person_test")
+	if count != 1 {
+		t.Errorf("%d != 1", count)
+	}
+
+	stale := &Person{100, 0, 0, "stale", "", 1}
+	err = dbmap.Upsert(stale)
+	if _, ok := err.(gorp.OptimisticLockError); !ok {
+		t.Errorf("Expected gorp.OptimisticLockError, got: %v", err)
+	}
+
+	// rows with a zero auto-increment key are new, and get generated keys
+	p3 := &Person{0, 0, 0, "ann", "new", 0}
+	p4 := &Person{0, 0, 0, "bea", "new", 0}
+	err = dbmap.Upsert(p3, p4)
+	if err != nil {
+		panic(err)
+	}
+	if p3.Id == 0 || p4.Id == 0 || p3.Id == p4.Id || p3.Version != 1 {
+		t.Errorf("Expected new rows with generated keys, got %v and %v", p3, p4)
+	}
+	count = selectInt(dbmap, "select count(*) from person_test")
+	if count != 3 {
+		t.Errorf("Expected 3 rows after upserting 2 new rows, got %d", count)
+	}
+	if p := _get(dbmap, Person{}, p3.Id).(*Person); p.FName != "ann" {
+		t.Errorf("Expected the first new row to be kept, got %v", p)
+	}
+}
+
+func TestUpsertColumnsUniqueTogether(t *testing.T) {
+	dbmap := newDBMap(t)
+	dbmap.AddTable(UniqueColumns{}).SetUniqueTogetherWithName("uniq_name", "FirstName", "LastName")
+	err := dbmap.CreateTablesIfNotExists()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	n1 := &UniqueColumns{"Steve", "Jobs", "Cupertino", 95014}
+	err = dbmap.UpsertColumns("uniq_name", nil, n1)
+	if err != nil {
+		panic(err)
+	}
+
+	// Only the city is overwritten on conflict
+	n2 := &UniqueColumns{"Steve", "Jobs", "Sunnyvale", 94085}
+	err = dbmap.UpsertColumns("uniq_name", func(col *gorp.ColumnMap) bool {
+		return col.ColumnName == "City"
+	}, n2)
+	if err != nil {
+		panic(err)
+	}
+
+	var rows []UniqueColumns
+	_, err = dbmap.Select(&rows, "select * from UniqueColumns")
+	if err != nil {
+		panic(err)
+	}
+	want := []UniqueColumns{{"Steve", "Jobs", "Sunnyvale", 95014}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("%v != %v", rows, want)
+	}
+
+	err = dbmap.UpsertColumns("no_such_constraint", nil, n2)
+	if err == nil {
+		t.Errorf("Expected an error for an unknown conflict target")
+	}
+}
+
+type SchemaV1 struct {
+	Id   int64
+	Name string
+}
+
+type SchemaV2 struct {
+	Id    int64
+	Name  string
+	Email string `db:"Email,size:100"`
+}
+
+func TestPlanSchema(t *testing.T) {
+	dbmap := newDBMap(t)
+	dbmap.AddTableWithName(SchemaV1{}, "schema_test").SetKeys(true, "Id")
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	dbmap.Db.Close()
+
+	dbmap = newDBMap(t)
+	table := dbmap.AddTableWithName(SchemaV2{}, "schema_test").SetKeys(true, "Id")
+	table.AddIndex("schema_test_email_idx", "", []string{"Email"})
+	defer dropAndClose(dbmap)
+	defer dbmap.Exec("drop table if exists " + gorp.SchemaVersionsTable)
+
+	plan, err := dbmap.PlanSchema()
+	if err != nil {
+		panic(err)
+	}
+	var kinds []gorp.SchemaChangeKind
+	for _, c := range plan.Changes {
+		kinds = append(kinds, c.Kind)
+	}
+	want := []gorp.SchemaChangeKind{gorp.AddColumnChange, gorp.CreateIndexChange}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("Expected changes %v, got %v: %v", want, kinds, plan.Sql())
+	}
+
+	dbmap.Db.SetMaxOpenConns(1)
+	if err := dbmap.ApplySchema("2", plan); err == nil || !strings.Contains(err.Error(), "MaxOpenConns") {
+		t.Errorf("Expected ApplySchema to refuse a single connection pool, got %v", err)
+	}
+	dbmap.Db.SetMaxOpenConns(0)
+
+	for i := 0; i < 2; i++ {
+		err = dbmap.ApplySchema("2", plan)
+		if err != nil {
+			panic(err)
+		}
+	}
+	count := selectInt(dbmap, "select count(*) from "+gorp.SchemaVersionsTable)
+	if count != 1 {
+		t.Errorf("Expected 1 recorded version, got %d", count)
+	}
+	// the versions are kept when the tables of the DbMap are reset
+	if err := dbmap.TruncateTables(); err != nil {
+		panic(err)
+	}
+	if count := selectInt(dbmap, "select count(*) from "+gorp.SchemaVersionsTable); count != 1 {
+		t.Errorf("Expected TruncateTables to keep the recorded version, got %d", count)
+	}
+
+	_insert(dbmap, &SchemaV2{Name: "bob", Email: "bob@example.com"})
+	plan, err = dbmap.PlanSchema()
+	if err != nil {
+		panic(err)
+	}
+	if len(plan.Changes) != 0 {
+		t.Errorf("Expected no changes after apply, got %v", plan.Sql())
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/1_create.up.sql":    {Data: []byte("create table mig_test (id integer not null primary key, name varchar(20))")},
+		"migrations/1_create.down.sql":  {Data: []byte("drop table mig_test")},
+		"migrations/2_email.up.sql":     {Data: []byte("alter table mig_test add column email varchar(100)")},
+		"migrations/2_email.down.sql":   {Data: []byte("alter table mig_test drop column email")},
+		"migrations/README.md":          {Data: []byte("ignored")},
+		"migrations/3_seed.up.sql":      {Data: []byte("insert into mig_test (id, name, email) values (1, 'bob', 'bob@example.com')")},
+		"migrations/unrelated.down.txt": {Data: []byte("ignored")},
+	}
+	migrations, err := gorp.LoadMigrations(fsys, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	if len(migrations) != 3 || migrations[1].Version != 2 || migrations[1].Name != "email" {
+		t.Fatalf("Unexpected migrations: %v", migrations)
+	}
+
+	dbmap := newDBMap(t)
+	defer dropAndClose(dbmap)
+	defer dbmap.Exec("drop table if exists " + gorp.MigrationsTable)
+	ctx := context.Background()
+
+	// the lock needs a connection of its own
+	dbmap.Db.SetMaxOpenConns(1)
+	if err := dbmap.Migrate(ctx, migrations); err == nil || !strings.Contains(err.Error(), "MaxOpenConns") {
+		t.Errorf("Expected Migrate to refuse a single connection pool, got %v", err)
+	}
+	dbmap.Db.SetMaxOpenConns(0)
+
+	// concurrent runs must not apply a migration twice
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = dbmap.Migrate(ctx, migrations)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := selectInt(dbmap, "select count(*) from mig_test"); count != 1 {
+		t.Errorf("Expected 1 seeded row, got %d", count)
+	}
+	// the history is kept when the tables of the DbMap are reset
+	if err := dbmap.DropTablesIfExists(); err != nil {
+		panic(err)
+	}
+	if err := dbmap.TruncateTables(); err != nil {
+		panic(err)
+	}
+	if applied, err := dbmap.AppliedMigrations(); err != nil || len(applied) != 3 {
+		t.Errorf("Expected 3 applied migrations after resetting the tables, got %v, %v", applied, err)
+	}
+
+	// migration 3 has no down script
+	err = dbmap.RollbackMigrations(ctx, migrations, 1)
+	if err == nil {
+		t.Fatal("Expected rollback of migration 3 to fail")
+	}
+
+	migrations[2].Down = "delete from mig_test"
+	err = dbmap.RollbackMigrations(ctx, migrations, 1)
+	if err != nil {
+		panic(err)
+	}
+	applied, err := dbmap.AppliedMigrations()
+	if err != nil {
+		panic(err)
+	}
+	if len(applied) != 1 || applied[0].Version != 1 || applied[0].Name != "create" {
+		t.Errorf("Expected only migration 1 to be applied, got %v", applied)
+	}
+
+	err = dbmap.RollbackMigrations(ctx, migrations, 0)
+	if err != nil {
+		panic(err)
+	}
+	applied, err = dbmap.AppliedMigrations()
+	if err != nil {
+		panic(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no applied migrations, got %v", applied)
+	}
+}
+
+func TestQueryBuilder(t *testing.T) {
+	dbmap := newDBMap(t)
+	dbmap.AddTableWithName(Invoice{}, "invoice_query_test").SetKeys(true, "Id").ColMap("Memo").Rename("memo_text")
+	dbmap.AddTableWithName(Person{}, "person_query_test").SetKeys(true, "Id").SetVersionCol("Version")
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	for i := 1; i <= 5; i++ {
+		_insert(dbmap, &Invoice{Created: int64(i), Memo: fmt.Sprintf("memo %d", i), PersonId: int64(i % 3), IsPaid: i%2 == 1})
+	}
+
+	list, err := dbmap.From(&Invoice{}).
+		Where("PersonId", "in", []int64{1, 2}).
+		Where("Memo", "like", "memo%").
+		OrderBy("Created desc").
+		Limit(2).
+		Offset(1).
+		Select()
+	if err != nil {
+		panic(err)
+	}
+	var created []int64
+	for _, v := range list {
+		created = append(created, v.(*Invoice).Created)
+	}
+	if want := []int64{4, 2}; !reflect.DeepEqual(created, want) {
+		t.Errorf("Expected invoices %v, got %v", want, created)
+	}
+
+	var paid []Invoice
+	err = dbmap.From(&Invoice{}).Where("IsPaid", "=", true).OrderBy("Created").SelectInto(&paid)
+	if err != nil {
+		panic(err)
+	}
+	if len(paid) != 3 || paid[0].Memo != "memo 1" {
+		t.Errorf("Expected 3 paid invoices starting with memo 1, got %v", paid)
+	}
+
+	count, err := dbmap.From(&Invoice{}).Where("Memo", "<>", "memo 1").Count()
+	if err != nil {
+		panic(err)
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 invoices, got %d", count)
+	}
+
+	_insert(dbmap, &Person{FName: "bob"})
+	var p Person
+	err = dbmap.From(&Person{}).Where("FName", "=", "bob").SelectOne(&p)
+	if err != nil {
+		panic(err)
+	}
+	if p.LName != "postget" {
+		t.Errorf("Expected PostGet hook to run, got LName %q", p.LName)
+	}
+
+	_, err = dbmap.From(&Invoice{}).Where("NoSuchField", "=", 1).Select()
+	if err == nil {
+		t.Errorf("Expected an error for an unknown field")
+	}
+	_, err = dbmap.From(&Invoice{}).Where("Memo", "; drop table", 1).Select()
+	if err == nil {
+		t.Errorf("Expected an error for an unsupported operator")
+	}
+	_, err = dbmap.From(&SchemaV1{}).OrderBy("", "Name sideways").Select()
+	if err == nil || !strings.Contains(err.Error(), "SchemaV1") {
+		t.Errorf("Expected an error for an unregistered type, got %v", err)
+	}
+}
+
+func TestSelectPage(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+
+	for i := 1; i <= 7; i++ {
+		_insert(dbmap, &Invoice{Created: int64(i), Memo: fmt.Sprintf("memo %d", i)})
+	}
+
+	query := "select * from invoice_test where " + columnName(dbmap, Invoice{}, "Created") + " > " + dbmap.Dialect.BindVar(0) +
+		" order by " + columnName(dbmap, Invoice{}, "Created")
+	list, total, err := dbmap.SelectPage(Invoice{}, query, gorp.Page{Number: 2, Size: 2}, 1)
+	if err != nil {
+		panic(err)
+	}
+	if total != 6 {
+		t.Errorf("Expected a total of 6 rows, got %d", total)
+	}
+	if len(list) != 2 || list[0].(*Invoice).Created != 4 || list[1].(*Invoice).Created != 5 {
+		t.Errorf("Expected invoices 4 and 5 on page 2, got %v", list)
+	}
+
+	list, _, err = gorp.SelectPage(dbmap, Invoice{}, query, gorp.Page{Number: 4, Size: 2}, 1)
+	if err != nil {
+		panic(err)
+	}
+	if len(list) != 0 {
+		t.Errorf("Expected an empty page after the last one, got %v", list)
+	}
+
+	var exec gorp.SqlExecutor = dbmap.WithContext(context.Background())
+	list, total, err = gorp.SelectPage(exec, Invoice{}, query, gorp.Page{Number: 3, Size: 2}, 1)
+	if err != nil {
+		panic(err)
+	}
+	if total != 6 || len(list) != 2 || list[0].(*Invoice).Created != 6 {
+		t.Errorf("Expected invoices 6 and 7 on page 3 of 6 rows, got %v of %d", list, total)
+	}
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+	list, total, err = tx.SelectPage(Invoice{}, query, gorp.Page{Number: 1, Size: 4}, 1)
+	if err != nil {
+		panic(err)
+	}
+	if total != 6 || len(list) != 4 {
+		t.Errorf("Expected 4 invoices of 6 on page 1 in a transaction, got %d of %d", len(list), total)
+	}
+}
+
+func TestSelectKeyset(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+
+	// pairs of invoices share a Created value, so the primary key breaks ties
+	for i := 0; i < 7; i++ {
+		_insert(dbmap, &Invoice{Created: int64(i / 2), Memo: fmt.Sprintf("memo %d", i)})
+	}
+
+	var memos []string
+	cursor := ""
+	pages := 0
+	for {
+		list, next, err := dbmap.From(&Invoice{}).OrderBy("Created desc").SelectKeyset(cursor, 3)
+		if err != nil {
+			panic(err)
+		}
+		for _, v := range list {
+			memos = append(memos, v.(*Invoice).Memo)
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+
+		// rows inserted before the cursor do not shift later pages
+		_insert(dbmap, &Invoice{Created: 100, Memo: "new"})
+	}
+	want := []string{"memo 6", "memo 4", "memo 5", "memo 2", "memo 3", "memo 0", "memo 1"}
+	if pages != 3 || !reflect.DeepEqual(memos, want) {
+		t.Errorf("Expected %v in 3 pages, got %v in %d", want, memos, pages)
+	}
+
+	_, _, err := dbmap.From(&Invoice{}).SelectKeyset("not a cursor", 3)
+	if err == nil {
+		t.Errorf("Expected an error for an invalid cursor")
+	}
+}
+
+type RelAuthor struct {
+	Id      int64
+	Name    string
+	Books   []*RelBook
+	Profile RelProfile `db:"-"`
+}
+
+type RelBook struct {
+	Id       int64
+	AuthorId int64
+	Title    string
+	Author   *RelAuthor `db:"-"`
+}
+
+type RelProfile struct {
+	Id       int64
+	AuthorId int64
+	Bio      string
+}
+
+func TestPreload(t *testing.T) {
+	dbmap := newDBMap(t)
+	dbmap.AddTableWithName(RelAuthor{}, "rel_author_test").SetKeys(true, "Id").
+		HasMany("Books", "AuthorId").
+		HasOne("Profile", "AuthorId")
+	dbmap.AddTableWithName(RelBook{}, "rel_book_test").SetKeys(true, "Id").BelongsTo("Author", "AuthorId")
+	dbmap.AddTableWithName(RelProfile{}, "rel_profile_test").SetKeys(true, "Id")
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	a1 := &RelAuthor{Name: "a1"}
+	a2 := &RelAuthor{Name: "a2"}
+	a3 := &RelAuthor{Name: "a3"}
+	_insert(dbmap, a1, a2, a3)
+	_insert(dbmap, &RelBook{AuthorId: a1.Id, Title: "b1"}, &RelBook{AuthorId: a2.Id, Title: "b2"}, &RelBook{AuthorId: a1.Id, Title: "b3"})
+	_insert(dbmap, &RelProfile{AuthorId: a2.Id, Bio: "bio"})
+
+	var authors []RelAuthor
+	_, err = dbmap.Preload("Books", "Profile").Select(&authors, "select * from rel_author_test order by Id")
+	if err != nil {
+		panic(err)
+	}
+	var titles [][]string
+	for _, a := range authors {
+		var ts []string
+		for _, b := range a.Books {
+			ts = append(ts, b.Title)
+		}
+		titles = append(titles, ts)
+	}
+	if want := [][]string{{"b1", "b3"}, {"b2"}, nil}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("Expected books %v, got %v", want, titles)
+	}
+	if authors[0].Profile.Bio != "" || authors[1].Profile.Bio != "bio" {
+		t.Errorf("Expected only a2 to have a profile, got %v", authors)
+	}
+
+	books, err := dbmap.From(&RelBook{}).OrderBy("Title").Preload("Author").Select()
+	if err != nil {
+		panic(err)
+	}
+	for _, b := range books {
+		b := b.(*RelBook)
+		if b.Author == nil || b.Author.Id != b.AuthorId {
+			t.Errorf("Expected book %s to belong to author %d, got %v", b.Title, b.AuthorId, b.Author)
+		}
+	}
+
+	obj, err := dbmap.Preload("Books").Get(RelAuthor{}, a2.Id)
+	if err != nil {
+		panic(err)
+	}
+	if a := obj.(*RelAuthor); len(a.Books) != 1 || a.Books[0].Title != "b2" {
+		t.Errorf("Expected a2 to have book b2, got %v", a.Books)
+	}
+
+	_, err = dbmap.Preload("NoSuchRelation").Get(RelAuthor{}, a2.Id)
+	if err == nil {
+		t.Errorf("Expected an error for an unknown relation")
+	}
+
+	// nil rows are skipped
+	ptrs := []*RelAuthor{nil, {Id: a1.Id}, nil}
+	if err := dbmap.Preload("Books").Load(&ptrs); err != nil || len(ptrs[1].Books) != 2 {
+		t.Errorf("Expected a1 to have 2 books, got %v, %v", ptrs[1].Books, err)
+	}
+
+	// a column missing from the related type doesn't stop the loading
+	if _, err := dbmap.Exec("alter table rel_book_test add column Extra integer"); err != nil {
+		panic(err)
+	}
+	authors = nil
+	_, err = dbmap.Preload("Books").Select(&authors, "select * from rel_author_test order by Id")
+	if !gorp.NonFatalError(err) || len(authors) != 3 || len(authors[0].Books) != 2 {
+		t.Errorf("Expected the books and a non-fatal error, got %v, %v", authors, err)
+	}
+	list, err := dbmap.From(&RelAuthor{}).Preload("Books").Select()
+	if !gorp.NonFatalError(err) || len(list) != 3 || len(list[0].(*RelAuthor).Books) != 2 {
+		t.Errorf("Expected the books and a non-fatal error, got %v, %v", list, err)
+	}
+}
+
+type FkParent struct {
+	Id   int64
+	Name string
+}
+
+type FkChild struct {
+	Id       int64
+	ParentId int64 `db:"ParentId,references:fk_parent_test.Id,ondelete:cascade"`
+}
+
+type FkGrandChild struct {
+	Id      int64
+	ChildId int64
+}
+
+func TestForeignKeys(t *testing.T) {
+	dbmap := newDBMap(t)
+	defer dbmap.Db.Close()
+	if _, ok := dbmap.Dialect.(gorp.SqliteDialect); ok {
+		// foreign keys are enforced per connection
+		dbmap.Db.SetMaxOpenConns(1)
+		_, err := dbmap.Exec("pragma foreign_keys = on")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// tables are added before the tables they reference
+	dbmap.AddTableWithName(FkGrandChild{}, "fk_grandchild_test").SetKeys(true, "Id").
+		ColMap("ChildId").SetForeignKey("fk_child_test", "Id").SetOnDelete("cascade")
+	child := dbmap.AddTableWithName(FkChild{}, "fk_child_test").SetKeys(true, "Id")
+	dbmap.AddTableWithName(FkParent{}, "fk_parent_test").SetKeys(true, "Id")
+
+	sql := child.SqlForCreate(false)
+	if !strings.Contains(sql, "references") || !strings.Contains(sql, "on delete cascade") {
+		t.Errorf("Expected a foreign key constraint in %s", sql)
+	}
+
+	dbmap.DropTablesIfExists()
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+
+	p := &FkParent{Name: "p"}
+	_insert(dbmap, p)
+	c := &FkChild{ParentId: p.Id}
+	_insert(dbmap, c)
+	_insert(dbmap, &FkGrandChild{ChildId: c.Id})
+
+	err = dbmap.Insert(&FkChild{ParentId: p.Id + 100})
+	if err == nil {
+		t.Errorf("Expected inserting a child of a missing parent to fail")
+	}
+
+	_del(dbmap, p)
+	if count := selectInt(dbmap, "select count(*) from fk_grandchild_test"); count != 0 {
+		t.Errorf("Expected the delete to cascade, got %d rows", count)
+	}
+
+	err = dbmap.DropTables()
+	if err != nil {
+		t.Errorf("Expected tables to be dropped in dependency order: %v", err)
+	}
+}
+
+func TestSelectIter(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+
+	for i := 0; i < 5; i++ {
+		_insert(dbmap, &Person{FName: fmt.Sprintf("p%d", i)})
+	}
+	_insert(dbmap, &TypeConversionExample{PersonJSON: Person{FName: "Bob"}, Name: CustomStringType("hi")})
+
+	cur, err := dbmap.SelectIter("select * from person_test order by " + columnName(dbmap, Person{}, "Id"))
+	if err != nil {
+		panic(err)
+	}
+	var names []string
+	var p Person
+	for cur.Next(&p) {
+		if p.LName != "postget" {
+			t.Errorf("Expected PostGet to run for row %d", p.Id)
+		}
+		names = append(names, p.FName)
+	}
+	if err := cur.Err(); err != nil {
+		t.Error(err)
+	}
+	if want := []string{"p0", "p1", "p2", "p3", "p4"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected %v, got %v", want, names)
+	}
+	if cur.Next(&p) {
+		t.Errorf("Expected Next to return false after the last row")
+	}
+
+	// the TypeConverter applies
+	cur, err = gorp.SelectIter(dbmap, "select * from type_conv_test")
+	if err != nil {
+		panic(err)
+	}
+	var tc TypeConversionExample
+	if !cur.Next(&tc) || tc.PersonJSON.FName != "Bob" || tc.Name != "hi" {
+		t.Errorf("Expected the converted row, got %v (%v)", tc, cur.Err())
+	}
+	cur.Close()
+
+	// single column rows, in a transaction
+	tx, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	cur, err = tx.SelectIter("select " + columnName(dbmap, Person{}, "Id") + " from person_test")
+	if err != nil {
+		panic(err)
+	}
+	var id, count int64
+	for cur.Next(&id) {
+		count++
+	}
+	if count != 5 || cur.Err() != nil {
+		t.Errorf("Expected 5 ids, got %d (%v)", count, cur.Err())
+	}
+	cur.Close()
+	if err := tx.Rollback(); err != nil {
+		panic(err)
+	}
+
+	// cancelling the context stops the iteration
+	ctx, cancel := context.WithCancel(context.Background())
+	cur, err = gorp.SelectIter(dbmap.WithContext(ctx), "select * from person_test")
+	if err != nil {
+		panic(err)
+	}
+	defer cur.Close()
+	if !cur.Next(&p) {
+		t.Fatalf("Expected a first row, got %v", cur.Err())
+	}
+	cancel()
+	if cur.Next(&p) {
+		t.Errorf("Expected Next to stop once the context is cancelled")
+	}
+	if cur.Err() != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", cur.Err())
+	}
+}
+
+func TestGenerics(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+
+	inv := &Invoice{Memo: "generic", PersonId: 3}
+	_insert(dbmap, inv)
+
+	got, err := gorp.GetT[Invoice](dbmap, inv.Id)
+	if err != nil {
+		panic(err)
+	}
+	if got == nil || got.Memo != "generic" {
+		t.Errorf("Expected the invoice, got %v", got)
+	}
+	missing, err := gorp.GetT[Invoice](dbmap, inv.Id+1)
+	if err != nil || missing != nil {
+		t.Errorf("Expected nil for a missing row, got %v, %v", missing, err)
+	}
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	defer tx.Rollback()
+	query := "select * from invoice_test where " + columnName(dbmap, Invoice{}, "PersonId") + " = " + dbmap.Dialect.BindVar(0)
+	invoices, err := gorp.SelectT[Invoice](tx, query, 3)
+	if err != nil {
+		panic(err)
+	}
+	if len(invoices) != 1 || invoices[0].Id != inv.Id {
+		t.Errorf("Expected [%v], got %v", *inv, invoices)
+	}
+	pointers, err := gorp.SelectT[*Invoice](tx, query, 3)
+	if err != nil {
+		panic(err)
+	}
+	if len(pointers) != 1 || pointers[0].Id != inv.Id {
+		t.Errorf("Expected [%v], got %v", inv, pointers)
+	}
+	ids, err := gorp.SelectT[int64](tx, "select "+columnName(dbmap, Invoice{}, "Id")+" from invoice_test")
+	if err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{inv.Id}) {
+		t.Errorf("Expected [%d], got %v", inv.Id, ids)
+	}
+
+	one, err := gorp.SelectOneT[Invoice](tx, query, 3)
+	if err != nil {
+		panic(err)
+	}
+	if one.Id != inv.Id {
+		t.Errorf("Expected %v, got %v", *inv, one)
+	}
+	_, err = gorp.SelectOneT[Invoice](tx, query, 4)
+	if err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+type SoftNote struct {
+	Id        int64
+	Body      string
+	DeletedAt *time.Time
+}
+
+type SoftFlag struct {
+	Id      int64
+	Name    string
+	Deleted bool
+	Version int64
+}
+
+func TestSoftDelete(t *testing.T) {
+	dbmap := newDBMap(t)
+	dbmap.AddTableWithName(SoftNote{}, "soft_note_test").SetKeys(true, "Id").SetSoftDeleteCol("DeletedAt")
+	flags := dbmap.AddTableWithName(SoftFlag{}, "soft_flag_test").SetKeys(true, "Id")
+	flags.SetVersionCol("Version")
+	flags.SetSoftDeleteCol("Deleted")
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	n1 := &SoftNote{Body: "n1"}
+	n2 := &SoftNote{Body: "n2"}
+	_insert(dbmap, n1, n2)
+
+	count, err := dbmap.Delete(n1)
+	if err != nil {
+		panic(err)
+	}
+	if count != 1 || n1.DeletedAt == nil {
+		t.Errorf("Expected 1 row soft deleted and DeletedAt set, got %d, %v", count, n1.DeletedAt)
+	}
+	if total := selectInt(dbmap, "select count(*) from soft_note_test"); total != 2 {
+		t.Errorf("Expected soft delete to keep the row, got %d rows", total)
+	}
+	if obj, err := dbmap.Get(SoftNote{}, n1.Id); err != nil || obj != nil {
+		t.Errorf("Expected Get to skip the deleted row, got %v, %v", obj, err)
+	}
+	obj, err := dbmap.Unscoped().Get(SoftNote{}, n1.Id)
+	if err != nil || obj == nil || obj.(*SoftNote).DeletedAt == nil {
+		t.Errorf("Expected the unscoped Get to return the deleted row, got %v, %v", obj, err)
+	}
+
+	list, err := dbmap.From(&SoftNote{}).Select()
+	if err != nil {
+		panic(err)
+	}
+	if len(list) != 1 || list[0].(*SoftNote).Id != n2.Id {
+		t.Errorf("Expected only %v, got %v", *n2, list)
+	}
+	n, err := dbmap.Unscoped().From(&SoftNote{}).Count()
+	if err != nil || n != 2 {
+		t.Errorf("Expected the unscoped count to be 2, got %d, %v", n, err)
+	}
+
+	// deleting a deleted row again matches nothing
+	count, err = dbmap.Delete(n1)
+	if err != nil || count != 0 {
+		t.Errorf("Expected 0 rows, got %d, %v", count, err)
+	}
+
+	count, err = dbmap.Restore(n1)
+	if err != nil || count != 1 || n1.DeletedAt != nil {
+		t.Errorf("Expected 1 row restored and DeletedAt cleared, got %d, %v, %v", count, err, n1.DeletedAt)
+	}
+	if obj, err := dbmap.Get(SoftNote{}, n1.Id); err != nil || obj == nil {
+		t.Errorf("Expected Get to return the restored row, got %v, %v", obj, err)
+	}
+
+	count, err = dbmap.HardDelete(n1)
+	if err != nil || count != 1 {
+		t.Errorf("Expected 1 row hard deleted, got %d, %v", count, err)
+	}
+	if total := selectInt(dbmap, "select count(*) from soft_note_test"); total != 1 {
+		t.Errorf("Expected hard delete to remove the row, got %d rows", total)
+	}
+
+	// bool columns, with versioning and in a transaction
+	f := &SoftFlag{Name: "f"}
+	_insert(dbmap, f)
+	tx, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	stale := *f
+	count, err = tx.Delete(f)
+	if err != nil || count != 1 || !f.Deleted {
+		t.Errorf("Expected 1 row soft deleted and Deleted set, got %d, %v, %v", count, err, f.Deleted)
+	}
+	if f.Version != stale.Version+1 {
+		t.Errorf("Expected soft delete to increment the version to %d, got %d", stale.Version+1, f.Version)
+	}
+	// a copy read before the delete must not undelete the row
+	stale.Name = "stale"
+	_, err = tx.Update(&stale)
+	if _, ok := err.(gorp.OptimisticLockError); !ok {
+		t.Errorf("Expected an OptimisticLockError updating a stale copy, got %v", err)
+	}
+	if obj, err := tx.Get(SoftFlag{}, f.Id); err != nil || obj != nil {
+		t.Errorf("Expected Get to skip the deleted row, got %v, %v", obj, err)
+	}
+	if obj, err := tx.Unscoped().Get(SoftFlag{}, f.Id); err != nil || obj == nil {
+		t.Errorf("Expected the unscoped Get to return the deleted row, got %v, %v", obj, err)
+	}
+	count, err = tx.Restore(&SoftFlag{Id: f.Id, Version: f.Version + 1})
+	if err != nil || count != 0 {
+		t.Errorf("Expected a stale version not to be restored, got %d, %v", count, err)
+	}
+	count, err = tx.Restore(f)
+	if err != nil || count != 1 || f.Deleted {
+		t.Errorf("Expected 1 row restored and Deleted cleared, got %d, %v, %v", count, err, f.Deleted)
+	}
+	version, err := gorp.SelectInt(tx, "select "+columnName(dbmap, SoftFlag{}, "Version")+" from soft_flag_test")
+	if err != nil {
+		panic(err)
+	}
+	if f.Version != stale.Version+2 || version != f.Version {
+		t.Errorf("Expected restore to increment the version to %d, got %d in the struct and %d in the table", stale.Version+2, f.Version, version)
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+}
+
+type StampedNote struct {
+	Id      int64
+	Body    string
+	Created time.Time  `db:"created,autocreatetime"`
+	Updated *time.Time `db:"updated,autoupdatetime"`
+}
+
+type StampedFlag struct {
+	Id        int64
+	CreatedAt gorp.NullTime
+	UpdatedAt sql.NullTime
+}
+
+func TestTimestampCols(t *testing.T) {
+	dbmap := newDBMap(t)
+	dbmap.AddTableWithName(StampedNote{}, "stamped_note_test").SetKeys(true, "Id")
+	dbmap.AddTableWithName(StampedFlag{}, "stamped_flag_test").SetKeys(true, "Id").
+		SetTimestampCols("CreatedAt", "UpdatedAt")
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	clock := time.Date(2020, 5, 1, 12, 30, 15, 123456789, time.FixedZone("x", 3600))
+	dbmap.Now = func() time.Time { return clock }
+	want := clock.UTC()
+	if p, ok := dbmap.Dialect.(gorp.TimePrecisioner); ok {
+		want = want.Truncate(p.TimePrecision())
+	}
+
+	n := &StampedNote{Body: "n"}
+	_insert(dbmap, n)
+	if n.Created != want || n.Updated == nil || *n.Updated != want {
+		t.Errorf("Expected both timestamps to be %v, got %v, %v", want, n.Created, n.Updated)
+	}
+	got := _get(dbmap, StampedNote{}, n.Id).(*StampedNote)
+	if !got.Created.Equal(want) || got.Updated == nil || !got.Updated.Equal(want) {
+		t.Errorf("Expected both stored timestamps to be %v, got %v, %v", want, got.Created, got.Updated)
+	}
+
+	clock = clock.Add(time.Hour)
+	later := want.Add(time.Hour)
+	_update(dbmap, n)
+	if n.Created != want || *n.Updated != later {
+		t.Errorf("Expected %v and %v, got %v, %v", want, later, n.Created, *n.Updated)
+	}
+
+	// a created time that is already set is kept
+	kept := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &StampedFlag{CreatedAt: gorp.NullTime{Time: kept, Valid: true}}
+	_insert(dbmap, f)
+	if f.CreatedAt.Time != kept || !f.UpdatedAt.Valid || f.UpdatedAt.Time != later {
+		t.Errorf("Expected %v and %v, got %v, %v", kept, later, f.CreatedAt, f.UpdatedAt)
+	}
+}
+
+type TrackedItem struct {
+	gorp.Snapshot
+	Id      int64
+	Name    string
+	Amount  int64
+	Version int64
+
+	PreUpdates  int `db:"-"`
+	PostUpdates int `db:"-"`
+}
+
+func (i *TrackedItem) PreUpdate(s gorp.SqlExecutor) error {
+	i.PreUpdates++
+	return nil
+}
+
+func (i *TrackedItem) PostUpdate(s gorp.SqlExecutor) error {
+	i.PostUpdates++
+	return nil
+}
+
+func TestDirtyTracking(t *testing.T) {
+	dbmap := newDBMap(t)
+	dbmap.AddTableWithName(TrackedItem{}, "tracked_item_test").SetKeys(true, "Id").SetVersionCol("Version")
+	err := dbmap.CreateTables()
+	if err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+
+	amount := columnName(dbmap, TrackedItem{}, "Amount")
+	setAmount := func(id, v int64) {
+		_, err := dbmap.Exec("update tracked_item_test set "+amount+" = "+dbmap.Dialect.BindVar(0)+
+			" where "+columnName(dbmap, TrackedItem{}, "Id")+" = "+dbmap.Dialect.BindVar(1), v, id)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	item := &TrackedItem{Name: "a", Amount: 1}
+	_insert(dbmap, item)
+
+	// nothing changed since the insert, so nothing is written
+	if count := _update(dbmap, item); count != 0 || item.Version != 1 {
+		t.Errorf("Expected an unchanged row to be skipped, got %d rows and version %d", count, item.Version)
+	}
+	if item.PreUpdates != 1 || item.PostUpdates != 1 {
+		t.Errorf("Expected the hooks of an unchanged row to be called once, got %d PreUpdate and %d PostUpdate",
+			item.PreUpdates, item.PostUpdates)
+	}
+
+	// a column changed by someone else is not overwritten
+	got := _get(dbmap, TrackedItem{}, item.Id).(*TrackedItem)
+	setAmount(item.Id, 99)
+	got.Name = "b"
+	if count := _update(dbmap, got); count != 1 || got.Version != 2 {
+		t.Errorf("Expected 1 row updated to version 2, got %d rows and version %d", count, got.Version)
+	}
+	got = _get(dbmap, TrackedItem{}, item.Id).(*TrackedItem)
+	if got.Name != "b" || got.Amount != 99 {
+		t.Errorf("Expected only Name to be written, got %v", *got)
+	}
+
+	// rows read with Select are tracked too, and the version is checked
+	var items []TrackedItem
+	_, err = dbmap.Select(&items, "select * from tracked_item_test")
+	if err != nil {
+		panic(err)
+	}
+	items[0].Amount = 5
+	_update(dbmap, &items[0])
+	stale := got
+	stale.Amount = 6
+	if _, err := dbmap.Update(stale); err == nil {
+		t.Errorf("Expected an OptimisticLockError for a stale row")
+	} else if _, ok := err.(gorp.OptimisticLockError); !ok {
+		t.Errorf("Expected an OptimisticLockError, got %v", err)
+	}
+
+	// rows without a snapshot are written in full
+	fresh := &TrackedItem{Id: item.Id, Name: "c", Version: items[0].Version}
+	if count := _update(dbmap, fresh); count != 1 {
+		t.Errorf("Expected 1 row updated, got %d", count)
+	}
+	if got := _get(dbmap, TrackedItem{}, item.Id).(*TrackedItem); got.Amount != 0 {
+		t.Errorf("Expected Amount to be written, got %v", *got)
+	}
+}
+
+func TestUpdateColumnsFilters(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+
+	inv := &Invoice{Memo: "m", PersonId: 1}
+	_insert(dbmap, inv)
+	table, err := dbmap.TableFor(reflect.TypeOf(Invoice{}), false)
+	if err != nil {
+		panic(err)
+	}
+	only := func(field string) gorp.ColumnFilter {
+		col := table.ColMap(field)
+		return func(c *gorp.ColumnMap) bool { return c == col }
+	}
+	inv.Memo, inv.PersonId = "m2", 2
+	_updateColumns(dbmap, only("Memo"), inv)
+	inv.Memo, inv.PersonId = "m3", 3
+	_updateColumns(dbmap, only("PersonId"), inv)
+
+	got := _get(dbmap, Invoice{}, inv.Id).(*Invoice)
+	if got.Memo != "m2" || got.PersonId != 3 {
+		t.Errorf("Expected each filter to apply to its own update, got %v", *got)
+	}
+}
+
+func TestCache(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+	dbmap.Cache = gorp.NewLRUCache(10, 0)
+
+	inv := &Invoice{Memo: "m1"}
+	_insert(dbmap, inv)
+	setMemo := func(memo string) {
+		_, err := dbmap.Exec("update invoice_test set "+columnName(dbmap, Invoice{}, "Memo")+" = "+dbmap.Dialect.BindVar(0), memo)
+		if err != nil {
+			panic(err)
+		}
+	}
+	memo := func(exec gorp.SqlExecutor) string {
+		obj, err := exec.Get(Invoice{}, inv.Id)
+		if err != nil {
+			panic(err)
+		}
+		if obj == nil {
+			return "<nil>"
+		}
+		return obj.(*Invoice).Memo
+	}
+
+	if got := memo(dbmap); got != "m1" {
+		t.Errorf("Expected m1, got %s", got)
+	}
+	// the second Get is served from the cache, and returns a copy
+	setMemo("changed")
+	obj := _get(dbmap, Invoice{}, inv.Id).(*Invoice)
+	if obj.Memo != "m1" {
+		t.Errorf("Expected the cached m1, got %s", obj.Memo)
+	}
+	obj.Memo = "modified copy"
+	if got := memo(dbmap); got != "m1" {
+		t.Errorf("Expected the cached row to be unchanged, got %s", got)
+	}
+
+	inv.Memo = "m2"
+	_update(dbmap, inv)
+	if got := memo(dbmap); got != "m2" {
+		t.Errorf("Expected Update to invalidate the row, got %s", got)
+	}
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	inv.Memo = "m3"
+	if _, err := tx.Update(inv); err != nil {
+		panic(err)
+	}
+	if got := memo(tx); got != "m3" {
+		t.Errorf("Expected the transaction to bypass the cache, got %s", got)
+	}
+	if got := memo(dbmap); got != "m2" {
+		t.Errorf("Expected the cached m2 until commit, got %s", got)
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+	if got := memo(dbmap); got != "m3" {
+		t.Errorf("Expected the commit to invalidate the row, got %s", got)
+	}
+
+	// a row read before an update must not be cached after the update
+	// invalidated it
+	stale := &Invoice{Memo: "stale"}
+	_insert(dbmap, stale)
+	inv.Memo = "m4"
+	_update(dbmap, inv)
+	armed := true
+	dbmap.AddInterceptors(gorp.InterceptorFunc(func(ev *gorp.QueryEvent, next func() error) error {
+		if armed && ev.Op == gorp.OpQueryRow && ev.Method == "Get" {
+			armed = false
+			inv.Memo = "m5"
+			_update(dbmap, inv)
+			// read the stale row, as a read racing the update might
+			ev.Args = []interface{}{stale.Id}
+		}
+		return next()
+	}))
+	if got := memo(dbmap); got != "stale" {
+		t.Errorf("Expected the stale row to be read, got %s", got)
+	}
+	if got := memo(dbmap); got != "m5" {
+		t.Errorf("Expected the stale row not to be cached, got %s", got)
+	}
+
+	_del(dbmap, inv)
+	if got := memo(dbmap); got != "<nil>" {
+		t.Errorf("Expected Delete to invalidate the row, got %s", got)
+	}
+}
+
+type CachedBlob struct {
+	Kind string
+	Name string
+	Data []byte
+}
+
+func TestCacheKeysAndCopies(t *testing.T) {
+	dbmap := newDBMap(t)
+	dbmap.AddTableWithName(CachedBlob{}, "cached_blob_test").SetKeys(false, "Kind", "Name")
+	if err := dbmap.CreateTables(); err != nil {
+		panic(err)
+	}
+	defer dropAndClose(dbmap)
+	dbmap.Cache = gorp.NewLRUCache(10, 0)
+
+	_insert(dbmap, &CachedBlob{"a|b", "c", []byte("one")}, &CachedBlob{"a", "b|c", []byte("two")})
+	data := func(kind, name string) string {
+		return string(_get(dbmap, CachedBlob{}, kind, name).(*CachedBlob).Data)
+	}
+	if got := data("a|b", "c"); got != "one" {
+		t.Errorf("Expected one, got %s", got)
+	}
+	// the composite keys of the rows must not collide in the cache
+	if got := data("a", "b|c"); got != "two" {
+		t.Errorf("Expected two, got %s", got)
+	}
+
+	// the bytes of a cached row are not shared with the rows returned
+	blob := _get(dbmap, CachedBlob{}, "a|b", "c").(*CachedBlob)
+	blob.Data[0] = 'X'
+	if got := data("a|b", "c"); got != "one" {
+		t.Errorf("Expected the cached bytes to be unchanged, got %s", got)
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	c := gorp.NewLRUCache(2, 0)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Expected the least recently used key to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Expected a=1, got %v, %v", v, ok)
+	}
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok || c.Len() != 1 {
+		t.Errorf("Expected a to be deleted, %d values left", c.Len())
+	}
+
+	c = gorp.NewLRUCache(2, 10*time.Millisecond)
+	c.Set("a", 1)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Expected a to expire")
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+
+	var events []gorp.QueryEvent
+	errInjected := errors.New("injected")
+	dbmap.AddInterceptors(
+		gorp.InterceptorFunc(func(ev *gorp.QueryEvent, next func() error) error {
+			err := next()
+			events = append(events, *ev)
+			return err
+		}),
+		gorp.InterceptorFunc(func(ev *gorp.QueryEvent, next func() error) error {
+			switch {
+			case strings.Contains(ev.Query, "fail_me"):
+				return errInjected
+			case strings.Contains(ev.Query, "rewrite_me"):
+				ev.Query = "select 42"
+			}
+			return next()
+		}),
+	)
+
+	inv := &Invoice{Memo: "m"}
+	_insert(dbmap, inv)
+	_get(dbmap, Invoice{}, inv.Id)
+	tx, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	if _, err := tx.Exec("delete from invoice_test"); err != nil {
+		panic(err)
+	}
+	if err := tx.Commit(); err != nil {
+		panic(err)
+	}
+
+	type summary struct {
+		Op     gorp.Operation
+		Method string
+		Table  string
+		Rows   int64
+	}
+	var got []summary
+	for _, ev := range events {
+		if ev.Err != nil || ev.Context == nil {
+			t.Errorf("Unexpected event %+v", ev)
+		}
+		got = append(got, summary{ev.Op, ev.Method, ev.Table, ev.RowsAffected})
+	}
+	want := []summary{
+		{gorp.OpExec, "Insert", "invoice_test", 1},
+		{gorp.OpQueryRow, "Get", "invoice_test", -1},
+		{gorp.OpBegin, "", "", -1},
+		{gorp.OpExec, "", "", 1},
+		{gorp.OpCommit, "", "", -1},
+	}
+	if len(got) > 0 && got[0].Op != gorp.OpExec {
+		// dialects returning the generated key insert with a query
+		want[0].Op, want[0].Rows = got[0].Op, got[0].Rows
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected events %v, got %v", want, got)
+	}
+	if len(events) == 5 && (events[2].Tx == nil || events[4].Tx != events[2].Tx || events[3].Tx != nil) {
+		t.Errorf("Expected the begin and commit events to report the transaction, got %v", events)
+	}
+
+	if n, err := dbmap.SelectInt("select 1 -- rewrite_me"); err != nil || n != 42 {
+		t.Errorf("Expected the rewritten query to return 42, got %d, %v", n, err)
+	}
+	if _, err := dbmap.Exec("delete from invoice_test -- fail_me"); err != errInjected {
+		t.Errorf("Expected the injected error, got %v", err)
+	}
+	var n int64
+	if err := dbmap.QueryRow("select 1 -- fail_me").Scan(&n); err != context.Canceled {
+		t.Errorf("Expected context.Canceled from a failed QueryRow, got %v", err)
+	}
+}
+
+type Account struct {
+	Id       int64
+	Name     string
+	Password string `db:"password,sensitive"`
+	Token    string
+}
+
+func initAccountTable(t *testing.T) *gorp.DbMap {
+	dbmap := newDBMap(t)
+	dbmap.AddTableWithName(Account{}, "account_test").SetKeys(true, "Id").
+		ColMap("Token").SetSensitive(true)
+	if err := dbmap.DropTablesIfExists(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbmap.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	return dbmap
+}
+
+func TestSensitiveColumns(t *testing.T) {
+	dbmap := initAccountTable(t)
+	defer dropAndClose(dbmap)
+
+	logBuffer := &bytes.Buffer{}
+	dbmap.TraceOn("", log.New(logBuffer, "gorptest:", 0))
+	var args []interface{}
+	dbmap.AddInterceptors(gorp.InterceptorFunc(func(ev *gorp.QueryEvent, next func() error) error {
+		args = append(args, ev.Args...)
+		return next()
+	}))
+
+	acct := &Account{Name: "ann", Password: "hunter2", Token: "tok-secret"}
+	_insert(dbmap, acct)
+	acct.Password = "hunter3"
+	_update(dbmap, acct)
+	if _, err := dbmap.Exec("update account_test set "+columnName(dbmap, Account{}, "Token")+" = "+
+		dbmap.Dialect.BindVar(0)+" where "+columnName(dbmap, Account{}, "Id")+" = "+dbmap.Dialect.BindVar(1),
+		gorp.Sensitive("tok-adhoc"), acct.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	got := _get(dbmap, Account{}, acct.Id).(*Account)
+	if got.Name != "ann" || got.Password != "hunter3" || got.Token != "tok-adhoc" {
+		t.Errorf("Expected the sensitive values to be written, got %+v", got)
+	}
+
+	logged := logBuffer.String()
+	for _, secret := range []string{"hunter2", "hunter3", "tok-secret", "tok-adhoc"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("Expected %q to be masked in the log, got:\n%s", secret, logged)
+		}
+		if s := fmt.Sprint(args...); strings.Contains(s, secret) {
+			t.Errorf("Expected %q to be masked in the interceptor args, got %s", secret, s)
+		}
+	}
+	if !strings.Contains(logged, "[redacted]") || !strings.Contains(logged, `"ann"`) {
+		t.Errorf("Expected masked and unmasked values in the log, got:\n%s", logged)
+	}
+	if redacted := fmt.Sprint(gorp.RedactArgs([]interface{}{"ann", gorp.Sensitive("x")})); redacted != "[ann [redacted]]" {
+		t.Errorf("Expected RedactArgs to mask sensitive values, got %s", redacted)
+	}
+}
+
+func TestStats(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+
+	var slow []gorp.QueryEvent
+	dbmap.StatsOn(time.Nanosecond, func(ev *gorp.QueryEvent) {
+		slow = append(slow, *ev)
+	})
+	defer dbmap.StatsOff()
+
+	for i := 0; i < 3; i++ {
+		inv := &Invoice{Memo: fmt.Sprintf("memo %d", i)}
+		_insert(dbmap, inv)
+		inv.Memo = "updated"
+		_update(dbmap, inv)
+	}
+	if _, err := dbmap.Exec("delete from no_such_table where id = 42"); err == nil {
+		t.Fatal("Expected an error deleting from a missing table")
+	}
+
+	var update, failed *gorp.StatementStats
+	stats := dbmap.Stats().Snapshot()
+	for i, st := range stats {
+		switch {
+		case strings.HasPrefix(st.Query, "update"):
+			update = &stats[i]
+		case strings.Contains(st.Query, "no_such_table"):
+			failed = &stats[i]
+		}
+		if i > 0 && st.Total > stats[i-1].Total {
+			t.Errorf("Expected statements by decreasing total latency, got %v", stats)
+		}
+	}
+	if update == nil || update.Count != 3 || update.RowsAffected != 3 || update.Errors != 0 {
+		t.Fatalf("Expected 3 updates of 1 row, got %+v", update)
+	}
+	if update.Avg != update.Total/3 || update.P99 <= 0 || update.P99 > update.Max {
+		t.Errorf("Unexpected latencies %+v", update)
+	}
+	if failed == nil || failed.Count != 1 || failed.Errors != 1 {
+		t.Errorf("Expected a failed delete, got %+v", failed)
+	}
+	if failed != nil && failed.Query != "delete from no_such_table where id = ?" {
+		t.Errorf("Expected the literal to be normalized, got %q", failed.Query)
+	}
+	// some dialects run two statements per insert
+	if len(slow) < 7 {
+		t.Errorf("Expected at least 7 slow statements, got %d", len(slow))
+	}
+
+	buf := &bytes.Buffer{}
+	if err := dbmap.Stats().WritePrometheus(buf); err != nil {
+		t.Fatal(err)
+	}
+	label := `{query="` + strings.ReplaceAll(update.Query, `"`, `\"`) + `"}`
+	for _, line := range []string{
+		"# TYPE gorp_queries_total counter",
+		"gorp_queries_total" + label + " 3",
+		"gorp_query_rows_affected_total" + label + " 3",
+		"# TYPE gorp_query_duration_seconds histogram",
+		"gorp_query_duration_seconds_count" + label + " 3",
+		`gorp_query_errors_total{query="delete from no_such_table where id = ?"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected %q in:\n%s", line, buf.String())
+		}
+	}
+
+	dbmap.Stats().Reset()
+	if n := len(dbmap.Stats().Snapshot()); n != 0 {
+		t.Errorf("Expected no statistics after Reset, got %d", n)
+	}
+
+	for query, want := range map[string]string{
+		"select * from t where id in ($1, $2,$3) and name = 'it''s'": "select * from t where id in (?) and name = ?",
+		"insert into t (a, b) values (?, ?), (?, ?);":                "insert into t (a, b) values (?)",
+		"select  t1.a\n from t1 where x > 1.5 and y = :2":            "select t1.a from t1 where x > ? and y = ?",
+	} {
+		if got := gorp.NormalizeQuery(query); got != want {
+			t.Errorf("NormalizeQuery(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+type retryDialect struct {
+	gorp.Dialect
+}
+
+var errConflict = errors.New("conflict")
+
+func (d retryDialect) IsRetryable(err error) bool {
+	return errors.Is(err, errConflict)
+}
+
+type fakePgError struct{ state string }
+
+func (e *fakePgError) Error() string    { return "pg error " + e.state }
+func (e *fakePgError) SQLState() string { return e.state }
+
+type fakeMySQLError struct {
+	Number  uint16
+	Message string
+}
+
+func (e *fakeMySQLError) Error() string { return e.Message }
+
+type fakeSqliteError struct {
+	Code int
+}
+
+func (e fakeSqliteError) Error() string { return "sqlite error" }
+
+func TestRunInTx(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+	count := func() int64 {
+		return selectInt(dbmap, "select count(*) from invoice_test")
+	}
+
+	err := dbmap.RunInTx(context.Background(), nil, func(tx *gorp.Transaction) error {
+		return tx.Insert(&Invoice{Memo: "committed"})
+	})
+	if err != nil || count() != 1 {
+		t.Errorf("Expected the transaction to commit, got %v and %d rows", err, count())
+	}
+
+	errFailed := errors.New("failed")
+	err = dbmap.RunInTx(nil, nil, func(tx *gorp.Transaction) error {
+		if err := tx.Insert(&Invoice{Memo: "rolled back"}); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if err != errFailed || count() != 1 {
+		t.Errorf("Expected the transaction to roll back, got %v and %d rows", err, count())
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("Expected the panic to resume, got %v", p)
+			}
+		}()
+		dbmap.RunInTx(nil, nil, func(tx *gorp.Transaction) error {
+			if err := tx.Insert(&Invoice{Memo: "panicked"}); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+	if count() != 1 {
+		t.Errorf("Expected the transaction to roll back on panic, got %d rows", count())
+	}
+
+	retrying := &gorp.DbMap{Db: dbmap.Db, Dialect: retryDialect{dbmap.Dialect},
+		TxRetry: &gorp.RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}}
+	attempts := 0
+	err = retrying.RunInTx(nil, nil, func(tx *gorp.Transaction) error {
+		attempts++
+		if _, err := tx.Exec("delete from invoice_test"); err != nil {
+			return err
+		}
+		if attempts < 3 {
+			return fmt.Errorf("attempt %d: %w", attempts, errConflict)
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 || count() != 0 {
+		t.Errorf("Expected the transaction to succeed on its third attempt, got %v after %d attempts and %d rows", err, attempts, count())
+	}
+
+	attempts = 0
+	retrying.TxRetry.MaxRetries = 1
+	err = retrying.RunInTx(nil, nil, func(tx *gorp.Transaction) error {
+		attempts++
+		return errConflict
+	})
+	if !errors.Is(err, errConflict) || attempts != 2 {
+		t.Errorf("Expected the conflict after 2 attempts, got %v after %d", err, attempts)
+	}
+
+	attempts = 0
+	err = retrying.RunInTx(nil, nil, func(tx *gorp.Transaction) error {
+		attempts++
+		return errFailed
+	})
+	if err != errFailed || attempts != 1 {
+		t.Errorf("Expected no retry of an error that is not retryable, got %v after %d attempts", err, attempts)
+	}
+
+	for _, c := range []struct {
+		dialect gorp.RetryClassifier
+		err     error
+		want    bool
+	}{
+		{gorp.PostgresDialect{}, fmt.Errorf("wrapped: %w", &fakePgError{"40001"}), true},
+		{gorp.PostgresDialect{}, &fakePgError{"40P01"}, true},
+		{gorp.PostgresDialect{}, &fakePgError{"23505"}, false},
+		{gorp.MySQLDialect{}, &fakeMySQLError{Number: 1213}, true},
+		{gorp.MySQLDialect{}, fmt.Errorf("wrapped: %w", &fakeMySQLError{Number: 1205}), true},
+		{gorp.MySQLDialect{}, &fakeMySQLError{Number: 1062}, false},
+		{gorp.SqliteDialect{}, fakeSqliteError{Code: 5}, true},
+		{gorp.SqliteDialect{}, fakeSqliteError{Code: 19}, false},
+		{gorp.SqliteDialect{}, errFailed, false},
+	} {
+		if got := c.dialect.IsRetryable(c.err); got != c.want {
+			t.Errorf("%T.IsRetryable(%v) = %v, want %v", c.dialect, c.err, got, c.want)
+		}
+	}
+}
+
+func TestBeginTx(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+
+	logBuffer := &bytes.Buffer{}
+	dbmap.TraceOn("", log.New(logBuffer, "gorptest:", 0))
+	var begins []gorp.QueryEvent
+	dbmap.AddInterceptors(gorp.InterceptorFunc(func(ev *gorp.QueryEvent, next func() error) error {
+		if ev.Op == gorp.OpBegin {
+			begins = append(begins, *ev)
+		}
+		return next()
+	}))
+
+	opts := &sql.TxOptions{ReadOnly: true}
+	tx, err := dbmap.BeginTx(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tx.Options(); got != *opts {
+		t.Errorf("Expected options %+v, got %+v", *opts, got)
+	}
+	if n, err := tx.SelectInt("select count(*) from invoice_test"); err != nil || n != 0 {
+		t.Errorf("Expected to read in a read only transaction, got %d, %v", n, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logBuffer.String(), "begin read only;") {
+		t.Errorf("Expected the options in the log, got:\n%s", logBuffer.String())
+	}
+	if len(begins) != 1 || begins[0].Query != "begin read only" || begins[0].TxOptions != opts {
+		t.Errorf("Expected the options in the begin event, got %+v", begins)
+	}
+
+	tx, err = dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tx.Options(); got != (sql.TxOptions{}) {
+		t.Errorf("Expected default options from Begin, got %+v", got)
+	}
+	tx.Rollback()
+	if begins[1].Query != "begin" || begins[1].TxOptions != nil {
+		t.Errorf("Expected a default begin event, got %+v", begins[1])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tx, err = dbmap.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := tx.Exec("delete from invoice_test"); err == nil {
+		t.Errorf("Expected the transaction to run with its context")
+	}
+	tx.Rollback()
+
+	if _, err := dbmap.BeginTx(ctx, nil); err != context.Canceled {
+		t.Errorf("Expected context.Canceled beginning with a done context, got %v", err)
+	}
+}
+
+// AuditedInvoice writes an audit invoice in the transaction inserting it.
+type AuditedInvoice struct {
+	Invoice
+}
+
+func (i *AuditedInvoice) PostInsert(s gorp.SqlExecutor) error {
+	return s.Insert(&Invoice{Memo: "audit " + i.Memo})
+}
+
+func TestNestedTransaction(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+	dbmap.AddTableWithName(AuditedInvoice{}, "audited_invoice_test").SetKeys(true, "Id")
+	if err := dbmap.CreateTablesIfNotExists(); err != nil {
+		t.Fatal(err)
+	}
+	memos := func(table string) []string {
+		var memos []string
+		if _, err := dbmap.Select(&memos, "select "+columnName(dbmap, Invoice{}, "Memo")+
+			" from "+table+" order by "+columnName(dbmap, Invoice{}, "Memo")); err != nil {
+			t.Fatal(err)
+		}
+		return memos
+	}
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Insert(&Invoice{Memo: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rolledBack, err := tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rolledBack.Insert(&AuditedInvoice{Invoice{Memo: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rolledBack.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rolledBack.Commit(); err != sql.ErrTxDone {
+		t.Errorf("Expected sql.ErrTxDone committing a rolled back transaction, got %v", err)
+	}
+
+	committed, err := tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := committed.Insert(&AuditedInvoice{Invoice{Memo: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	inner, err := committed.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Insert(&Invoice{Memo: "d"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := committed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	open, err := tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := open.Insert(&Invoice{Memo: "e"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.WithContext(context.Background()).(*gorp.Transaction).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != sql.ErrTxDone {
+		t.Errorf("Expected sql.ErrTxDone committing a transaction committed through a copy, got %v", err)
+	}
+	if err := open.Rollback(); err != sql.ErrTxDone {
+		t.Errorf("Expected sql.ErrTxDone rolling back a nested transaction after its parent, got %v", err)
+	}
+	if _, err := tx.Begin(); err != sql.ErrTxDone {
+		t.Errorf("Expected sql.ErrTxDone beginning in a committed transaction, got %v", err)
+	}
+
+	want := []string{"a", "audit c", "d", "e"}
+	if got := memos("invoice_test"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected memos %v, got %v", want, got)
+	}
+	if got := memos("audited_invoice_test"); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("Expected audited memos [c], got %v", got)
+	}
+}
+
+// NotifiedInvoice records notifications of its insert once committed.
+type NotifiedInvoice struct {
+	Invoice
+	Log *[]string `db:"-"`
+}
+
+func (i *NotifiedInvoice) PostInsert(s gorp.SqlExecutor) error {
+	gorp.OnRollback(s, func() { *i.Log = append(*i.Log, "rolled back "+i.Memo) })
+	return gorp.OnCommit(s, func() {
+		*i.Log = append(*i.Log, "committed "+i.Memo)
+		if i.Memo == "panic" {
+			panic("boom")
+		}
+	})
+}
+
+func TestTransactionCallbacks(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+	dbmap.AddTableWithName(NotifiedInvoice{}, "notified_invoice_test").SetKeys(true, "Id")
+	if err := dbmap.CreateTablesIfNotExists(); err != nil {
+		t.Fatal(err)
+	}
+	var log []string
+	expect := func(want ...string) {
+		t.Helper()
+		if !reflect.DeepEqual(log, want) {
+			t.Errorf("Expected callbacks %q, got %q", want, log)
+		}
+		log = nil
+	}
+
+	if err := dbmap.Insert(&NotifiedInvoice{Invoice{Memo: "a"}, &log}); err != nil {
+		t.Fatal(err)
+	}
+	expect("committed a")
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Insert(&NotifiedInvoice{Invoice{Memo: "b"}, &log}); err != nil {
+		t.Fatal(err)
+	}
+	tx.OnCommit(func() { log = append(log, "done") })
+	expect()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expect("committed b", "done")
+
+	tx, err = dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Insert(&NotifiedInvoice{Invoice{Memo: "c"}, &log}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	expect("rolled back c")
+
+	tx, err = dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err := tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Insert(&NotifiedInvoice{Invoice{Memo: "d"}, &log}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	expect("rolled back d")
+	nested, err = tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Insert(&NotifiedInvoice{Invoice{Memo: "e"}, &log}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expect()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expect("committed e")
+
+	// nested transactions still open are rolled back with the outer one
+	tx, err = dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.OnRollback(func() { log = append(log, "outer") })
+	nested, err = tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Insert(&NotifiedInvoice{Invoice{Memo: "f"}, &log}); err != nil {
+		t.Fatal(err)
+	}
+	inner, err := nested.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Insert(&NotifiedInvoice{Invoice{Memo: "g"}, &log}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	expect("outer", "rolled back f", "rolled back g")
+
+	// nested transactions still open are committed with the outer one
+	tx, err = dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.OnCommit(func() { log = append(log, "outer") })
+	nested, err = tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Insert(&NotifiedInvoice{Invoice{Memo: "h"}, &log}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expect("outer", "committed h")
+	if err := nested.Commit(); err != sql.ErrTxDone {
+		t.Errorf("Expected the nested transaction to be committed, got %v", err)
+	}
+	expect()
+
+	tx, err = dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err = tx.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested.OnCommit(func() { log = append(log, "nested") })
+	inner, err = nested.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.Insert(&NotifiedInvoice{Invoice{Memo: "i"}, &log}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nested.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expect()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	expect("nested", "committed i")
+
+	// outside of a transaction, a panicking callback is reported by the hook
+	err = dbmap.Insert(&NotifiedInvoice{Invoice{Memo: "panic"}, &log})
+	var cbErr *gorp.CallbackError
+	if !errors.As(err, &cbErr) || !strings.Contains(cbErr.Error(), "boom") {
+		t.Errorf("Expected a CallbackError for the panic, got %v", err)
+	}
+	expect("committed panic")
+
+	tx, err = dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.OnCommit(func() { log = append(log, "first") })
+	tx.OnCommit(func() { panic("boom") })
+	tx.OnCommit(func() { log = append(log, "last") })
+	err = tx.Commit()
+	if !errors.As(err, &cbErr) || len(cbErr.Errors) != 1 || !strings.Contains(cbErr.Error(), "boom") {
+		t.Errorf("Expected a CallbackError for the panic, got %v", err)
+	}
+	expect("first", "last")
+	if err := tx.Rollback(); err != sql.ErrTxDone {
+		t.Errorf("Expected the transaction to be committed, got %v", err)
+	}
+}
+
+type TenantNote struct {
+	Id       int64
+	TenantId int64
+	FolderId int64
+	Body     string
+	Version  int64
+	Deleted  bool
+}
+
+type TenantFolder struct {
+	Id    int64
+	Notes []*TenantNote
+}
+
+func TestTenantScoping(t *testing.T) {
+	dbmap := newDBMap(t)
+	notes := dbmap.AddTableWithName(TenantNote{}, "tenant_note_test").SetKeys(true, "Id")
+	notes.SetTenantCol("TenantId")
+	notes.SetVersionCol("Version")
+	notes.SetSoftDeleteCol("Deleted")
+	dbmap.AddTableWithName(TenantFolder{}, "tenant_folder_test").SetKeys(true, "Id").HasMany("Notes", "FolderId")
+	if err := dbmap.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	defer dropAndClose(dbmap)
+	tenant1 := dbmap.WithContext(gorp.WithTenant(context.Background(), int64(1)))
+	tenant2 := dbmap.WithContext(gorp.WithTenant(context.Background(), int64(2)))
+	tenantOf := func(id int64) int64 {
+		return selectInt(dbmap, "select "+columnName(dbmap, TenantNote{}, "TenantId")+
+			" from tenant_note_test where "+columnName(dbmap, TenantNote{}, "Id")+" = "+strconv.FormatInt(id, 10))
+	}
+
+	if err := dbmap.Insert(&TenantNote{Body: "none"}); !errors.Is(err, gorp.ErrNoTenant) {
+		t.Errorf("Expected ErrNoTenant inserting without a tenant, got %v", err)
+	}
+	folder := &TenantFolder{}
+	_insert(dbmap, folder)
+	n1 := &TenantNote{Body: "n1", FolderId: folder.Id}
+	n2 := &TenantNote{Body: "n2", FolderId: folder.Id, TenantId: 1}
+	if err := tenant1.Insert(n1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tenant2.Insert(n2); err != nil {
+		t.Fatal(err)
+	}
+	if n1.TenantId != 1 || n2.TenantId != 2 || tenantOf(n2.Id) != 2 {
+		t.Errorf("Expected the rows to be stamped with their tenant, got %d and %d", n1.TenantId, n2.TenantId)
+	}
+
+	if obj, err := tenant1.Get(TenantNote{}, n1.Id); err != nil || obj == nil {
+		t.Errorf("Expected the row of the tenant, got %v, %v", obj, err)
+	}
+	if obj, err := tenant2.Get(TenantNote{}, n1.Id); err != nil || obj != nil {
+		t.Errorf("Expected no row of another tenant, got %v, %v", obj, err)
+	}
+	if _, err := dbmap.Get(TenantNote{}, n1.Id); !errors.Is(err, gorp.ErrNoTenant) {
+		t.Errorf("Expected ErrNoTenant getting without a tenant, got %v", err)
+	}
+
+	list, err := tenant2.(*gorp.DbMap).From(&TenantNote{}).Select()
+	if err != nil || len(list) != 1 || list[0].(*TenantNote).Id != n2.Id {
+		t.Errorf("Expected the query builder to return only the row of the tenant, got %v, %v", list, err)
+	}
+	if _, err := dbmap.From(&TenantNote{}).Count(); !errors.Is(err, gorp.ErrNoTenant) {
+		t.Errorf("Expected ErrNoTenant counting without a tenant, got %v", err)
+	}
+	var folders []TenantFolder
+	if _, err := tenant1.(*gorp.DbMap).Preload("Notes").Select(&folders, "select * from tenant_folder_test"); err != nil {
+		t.Fatal(err)
+	}
+	if len(folders) != 1 || len(folders[0].Notes) != 1 || folders[0].Notes[0].Id != n1.Id {
+		t.Errorf("Expected Preload to load only the row of the tenant, got %v", folders)
+	}
+
+	stolen := *n1
+	stolen.Body = "stolen"
+	_, err = tenant2.Update(&stolen)
+	if ole, ok := err.(gorp.OptimisticLockError); !ok || ole.RowExists {
+		t.Errorf("Expected an OptimisticLockError without a row updating the row of another tenant, got %v", err)
+	}
+	n1.Body, n1.TenantId = "n1 updated", 2
+	if count, err := tenant1.Update(n1); err != nil || count != 1 {
+		t.Errorf("Expected 1 row updated, got %d, %v", count, err)
+	}
+	if tenant := tenantOf(n1.Id); tenant != 1 {
+		t.Errorf("Expected Update to keep the tenant of the row, got %d", tenant)
+	}
+	n1.TenantId = 1
+
+	if _, err := tenant2.Delete(n1); err == nil {
+		t.Errorf("Expected deleting the row of another tenant to fail")
+	}
+	tx, err := tenant1.(*gorp.DbMap).Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj, err := tx.Get(TenantNote{}, n2.Id); err != nil || obj != nil {
+		t.Errorf("Expected no row of another tenant in a transaction, got %v, %v", obj, err)
+	}
+	if count, err := tx.Delete(n1); err != nil || count != 1 {
+		t.Errorf("Expected 1 row deleted, got %d, %v", count, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := tenant2.(*gorp.DbMap).Restore(n1); err != nil || count != 0 {
+		t.Errorf("Expected no row of another tenant restored, got %d, %v", count, err)
+	}
+	if count, err := tenant1.(*gorp.DbMap).Restore(n1); err != nil || count != 1 {
+		t.Errorf("Expected 1 row restored, got %d, %v", count, err)
+	}
+
+	if _, ok := dbmap.Dialect.(gorp.Upserter); ok {
+		if err := tenant1.(*gorp.DbMap).Upsert(n1); err == nil {
+			t.Errorf("Expected upserts to be refused for a table scoped by tenant")
+		}
+	}
+}
+
 func TestPersistentUser(t *testing.T) {
 	dbmap := newDBMap(t)
 	dbmap.Exec("drop table if exists PersistentUser")
@@ -1602,6 +3628,73 @@ func TestMultiple(t *testing.T) {
 	}
 }
 
+func TestInsertBatch(t *testing.T) {
+	dbmap := initDBMap(t)
+	defer dropAndClose(dbmap)
+
+	inv1 := &Invoice{0, 100, 200, "a", 0, false}
+	p1 := &Person{0, 0, 0, "bob", "smith", 0}
+	inv2 := &Invoice{0, 300, 400, "b", 0, true}
+	p2 := &Person{0, 0, 0, "jane", "doe", 0}
+	err := dbmap.InsertBatch(inv1, p1, inv2, p2)
+	if err != nil {
+		panic(err)
+	}
+
+	if inv1.Id == 0 || inv2.Id == 0 || inv1.Id == inv2.Id {
+		t.Errorf("InsertBatch didn't bind generated PKs: %d, %d", inv1.Id, inv2.Id)
+	}
+	for _, inv := range []*Invoice{inv1, inv2} {
+		obj := _get(dbmap, Invoice{}, inv.Id)
+		if !reflect.DeepEqual(inv, obj) {
+			t.Errorf("%v != %v", inv, obj)
+		}
+	}
+	for _, p := range []*Person{p1, p2} {
+		if p.Created == 0 || p.LName != "postinsert" {
+			t.Errorf("InsertBatch didn't run hooks: %v", p)
+		}
+		if p.Version != 1 {
+			t.Errorf("InsertBatch didn't incr Version: %d != %d", 1, p.Version)
+		}
+		obj := _get(dbmap, Person{}, p.Id)
+		if obj.(*Person).FName != p.FName {
+			t.Errorf("%v != %v", p, obj)
+		}
+	}
+
+	// Enough rows to exceed the bind variable limit of any dialect's
+	// single statement.
+	invoices := make([]interface{}, 0, 15000)
+	for i := 0; i < cap(invoices); i++ {
+		invoices = append(invoices, &Invoice{Memo: fmt.Sprintf("batch %d", i)})
+	}
+	trans, err := dbmap.Begin()
+	if err != nil {
+		panic(err)
+	}
+	err = trans.InsertBatch(invoices...)
+	if err != nil {
+		panic(err)
+	}
+	err = trans.Commit()
+	if err != nil {
+		panic(err)
+	}
+
+	count := selectInt(dbmap, "select count(*) from invoice_test")
+	if count != int64(len(invoices))+2 {
+		t.Errorf("%d != %d", count, len(invoices)+2)
+	}
+	for _, i := range []int{0, 7000, len(invoices) - 1} {
+		inv := invoices[i].(*Invoice)
+		obj := _get(dbmap, Invoice{}, inv.Id)
+		if !reflect.DeepEqual(inv, obj) {
+			t.Errorf("%v != %v", inv, obj)
+		}
+	}
+}
+
 func TestCrud(t *testing.T) {
 	dbmap := initDBMap(t)
 	defer dropAndClose(dbmap)
@@ -1989,14 +4082,16 @@ func TestWithStringPk(t *testing.T) {
 
 // TestSqlExecutorInterfaceSelects ensures that all gorp.DbMap methods starting with Select...
 // are also exposed in the gorp.SqlExecutor interface. Select...  functions can always
-// run on Pre/Post hooks.
+// run on Pre/Post hooks.  SelectPage and SelectIter were added after the interface
+// was frozen, and hooks run them with the package functions of the same name.
 func TestSqlExecutorInterfaceSelects(t *testing.T) {
 	dbMapType := reflect.TypeOf(&gorp.DbMap{})
 	sqlExecutorType := reflect.TypeOf((*gorp.SqlExecutor)(nil)).Elem()
+	packageFuncs := map[string]bool{"SelectPage": true, "SelectIter": true}
 	numDbMapMethods := dbMapType.NumMethod()
 	for i := 0; i < numDbMapMethods; i += 1 {
 		dbMapMethod := dbMapType.Method(i)
-		if !strings.HasPrefix(dbMapMethod.Name, "Select") {
+		if !strings.HasPrefix(dbMapMethod.Name, "Select") || packageFuncs[dbMapMethod.Name] {
 			continue
 		}
 		if _, found := sqlExecutorType.MethodByName(dbMapMethod.Name); !found {
@@ -0,0 +1,202 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Operation is the kind of database call described by a QueryEvent.
+type Operation string
+
+const (
+	OpExec     Operation = "exec"
+	OpQuery    Operation = "query"
+	OpQueryRow Operation = "query row"
+	OpPrepare  Operation = "prepare"
+	OpBegin    Operation = "begin"
+	OpCommit   Operation = "commit"
+	OpRollback Operation = "rollback"
+)
+
+// errNoResult is returned when an interceptor returns nil without calling
+// next, leaving nothing to return to the caller.
+var errNoResult = errors.New("gorp: interceptor returned no result")
+
+// QueryEvent describes a database call passed through the Interceptors
+// of a DbMap.
+type QueryEvent struct {
+	Op Operation
+
+	// Context is the context of the call, or context.Background() if the
+	// executor has none.  An interceptor of OpBegin may replace it before
+	// calling next, e.g. with a context carrying a tracing span; the
+	// Transaction then runs its statements, and reports its commit or
+	// rollback, with that context.
+	Context context.Context
+
+	// Query and Args are the statement and its bind arguments, after named
+	// parameters have been expanded.  An interceptor may change them
+	// before calling next to rewrite the statement.  Query is "begin",
+	// "commit" or "rollback" for the transaction operations, with the
+	// options of the transaction after "begin" if it has some, as in
+	// "begin isolation level serializable read only".
+	Query string
+	Args  []interface{}
+
+	// TxOptions holds the options of the transaction begun by an OpBegin
+	// call, or nil if it has the default ones.
+	TxOptions *sql.TxOptions
+
+	// Tx is the database transaction begun, committed or rolled back by an
+	// OpBegin, OpCommit or OpRollback call, and nil for the other calls.
+	// It is set for OpBegin once next returns.  A Transaction and its
+	// copies made with WithContext report the same Tx, so that it can
+	// match the commit or rollback of a transaction with its begin.
+	Tx *sql.Tx
+
+	// Method is the gorp method making the call: Insert, InsertBatch,
+	// Upsert, Update, Delete, Restore, Get, Select or Count, or empty for
+	// SQL run with Exec, Query, QueryRow and Prepare.  The Select* methods,
+	// SelectIter, SelectPage and the Query builder report Select.
+	Method string
+
+	// Table is the name of the table of the gorp method making the call,
+	// such as Insert or Get, and empty for SQL run with Exec, Select and
+	// the like.
+	Table string
+
+	// The fields below are set once next returns.
+
+	Duration time.Duration
+
+	// RowsAffected is the number of rows affected by an OpExec call, or -1
+	// if unknown.
+	RowsAffected int64
+
+	Err error
+}
+
+// Interceptor wraps the database calls of a DbMap and its Transactions:
+// Exec, Query, QueryRow and Prepare, and beginning, committing and
+// rolling back transactions.  Intercept must call next to make the call,
+// and returns the error reported to the caller, which is usually the one
+// returned by next.
+//
+// An interceptor can observe the event, e.g. to record metrics or log
+// slow queries, rewrite its Query and Args before calling next, or fail
+// the call by returning an error without calling next.  Statements run
+// through a *sql.Stmt returned by Prepare are not intercepted.
+//
+// Since the *sql.Row of a QueryRow call carries its own error, an
+// interceptor failing a QueryRow call makes the row report
+// context.Canceled rather than the error it returned.
+type Interceptor interface {
+	Intercept(ev *QueryEvent, next func() error) error
+}
+
+// InterceptorFunc adapts a function to the Interceptor interface.
+type InterceptorFunc func(ev *QueryEvent, next func() error) error
+
+// Intercept calls f(ev, next).
+func (f InterceptorFunc) Intercept(ev *QueryEvent, next func() error) error {
+	return f(ev, next)
+}
+
+// AddInterceptors appends interceptors to the chain wrapping the database
+// calls of this DbMap.  The first interceptor added is the outermost.
+func (m *DbMap) AddInterceptors(interceptors ...Interceptor) {
+	m.interceptors = append(m.interceptors, interceptors...)
+}
+
+// observed reports whether the calls of m are passed to interceptors or
+// recorded in statistics, which need the details of QueryEvents.
+func (m *DbMap) observed() bool {
+	return len(m.interceptors) > 0 || m.stats != nil
+}
+
+// intercept runs call through the interceptors of m, and records it in
+// the statistics of m.  call makes the database call with the Query and
+// Args of ev.
+func (m *DbMap) intercept(ev *QueryEvent, call func(ev *QueryEvent) error) error {
+	if !m.observed() {
+		return call(ev)
+	}
+	if ev.Context == nil {
+		ev.Context = context.Background()
+	}
+	ev.RowsAffected = -1
+
+	var run func(i int) error
+	run = func(i int) error {
+		if i == len(m.interceptors) {
+			start := time.Now()
+			err := call(ev)
+			ev.Duration = time.Since(start)
+			ev.Err = err
+			return err
+		}
+		return m.interceptors[i].Intercept(ev, func() error {
+			return run(i + 1)
+		})
+	}
+	err := run(0)
+	if m.stats != nil {
+		m.stats.record(ev, err)
+	}
+	return err
+}
+
+// withCall returns a copy of exec reporting the calls it makes as calls
+// by the gorp method named method, on table t unless t is nil, if there
+// are interceptors to report them to.
+func withCall(exec SqlExecutor, t *TableMap, method string) SqlExecutor {
+	switch e := exec.(type) {
+	case *DbMap:
+		if len(e.interceptors) > 0 {
+			copy := *e
+			copy.method = method
+			if t != nil {
+				copy.table = t.TableName
+			}
+			return &copy
+		}
+	case *Transaction:
+		if len(e.dbmap.interceptors) > 0 {
+			copy := *e
+			copy.method = method
+			if t != nil {
+				copy.table = t.TableName
+			}
+			return &copy
+		}
+	}
+	return exec
+}
+
+// newEvent returns the QueryEvent of a call by e.
+func newEvent(e SqlExecutor, op Operation, query string, args []interface{}) (*DbMap, *QueryEvent) {
+	ev := &QueryEvent{Op: op, Query: query, Args: args}
+	switch e := e.(type) {
+	case *DbMap:
+		ev.Context, ev.Method, ev.Table = e.ctx, e.method, e.table
+		return e, ev
+	case *Transaction:
+		ev.Context, ev.Method, ev.Table = e.ctx, e.method, e.table
+		return e.dbmap, ev
+	}
+	return &DbMap{}, ev
+}
+
+// interceptedRow returns a row reporting an error, for a QueryRow call
+// failed by an interceptor.
+func interceptedRow(executor executor, query string, args ...interface{}) *sql.Row {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return executor.QueryRowContext(ctx, query, args...)
+}
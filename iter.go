@@ -0,0 +1,197 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+)
+
+// Cursor streams the rows of a query one at a time, instead of loading
+// them all into memory as Select does.  It is returned by SelectIter,
+// DbMap.SelectIter and Transaction.SelectIter.
+//
+// Example:
+//
+//	cur, err := dbmap.SelectIter("select * from invoice_test")
+//	if err != nil {
+//		return err
+//	}
+//	defer cur.Close()
+//	var inv Invoice
+//	for cur.Next(&inv) {
+//		// use inv
+//	}
+//	return cur.Err()
+type Cursor struct {
+	dbmap *DbMap
+	exec  SqlExecutor
+	ctx   context.Context
+	rows  *sql.Rows
+	cols  []string
+
+	// the column mapping of the last destination type
+	t               reflect.Type
+	tableName       string
+	intoStruct      bool
+	colToFieldIndex [][]int
+
+	err         error
+	nonFatalErr error
+}
+
+// SelectIter runs query and returns a Cursor over its rows; see the
+// SelectIter function.  It is not part of SqlExecutor: hooks call the
+// function.
+func (m *DbMap) SelectIter(query string, args ...interface{}) (*Cursor, error) {
+	return SelectIter(m, query, args...)
+}
+
+// SelectIter runs query with e, a DbMap or a Transaction, and returns a
+// Cursor over its rows.  The Cursor must be closed once done with.
+//
+// Rows are scanned as Select scans them, using the TypeConverter and
+// calling PostGet hooks, into the value passed to Cursor.Next.  If the
+// executor has a context, cancelling it stops the iteration.
+func SelectIter(e SqlExecutor, query string, args ...interface{}) (*Cursor, error) {
+	m := extractDbMap(e)
+	if m == nil {
+		return nil, fmt.Errorf("gorp: SelectIter needs a DbMap or a Transaction, got %T", e)
+	}
+	if m.ExpandSliceArgs {
+		expandSliceArgs(&query, args...)
+	}
+
+	return selectIter(m, e, query, args...)
+}
+
+func selectIter(m *DbMap, exec SqlExecutor, query string, args ...interface{}) (*Cursor, error) {
+	if len(args) == 1 {
+		query, args = maybeExpandNamedQuery(m, query, args)
+	}
+
+	rows, err := withCall(exec, nil, "Select").Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
+	_, ctx := extractExecutorAndContext(exec)
+	return &Cursor{dbmap: m, exec: exec, ctx: ctx, rows: rows, cols: cols}, nil
+}
+
+// Next scans the next row into dest, which must be a pointer to a struct,
+// or to a single value if the query returns one column.  Fields without a
+// matching column are left zero.  Next returns false when there are no
+// more rows or an error occurred, which Err then returns; the Cursor is
+// closed in both cases.
+func (c *Cursor) Next(dest interface{}) bool {
+	if c.err != nil || c.rows == nil {
+		return false
+	}
+	if c.ctx != nil {
+		if err := c.ctx.Err(); err != nil {
+			c.fail(err)
+			return false
+		}
+	}
+
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		c.fail(fmt.Errorf("gorp: cannot scan into non-pointer %T", dest))
+		return false
+	}
+	if err := c.mapColumns(dest, v.Type().Elem()); err != nil {
+		c.fail(err)
+		return false
+	}
+
+	if !c.rows.Next() {
+		c.fail(c.rows.Err())
+		return false
+	}
+
+	elem := v.Elem()
+	elem.Set(reflect.Zero(elem.Type()))
+	if c.tableName != "" {
+		dest.(DynamicTable).SetTableName(c.tableName)
+	}
+	if err := scanRow(c.dbmap.TypeConverter, c.rows, elem, len(c.cols), c.colToFieldIndex, c.intoStruct); err != nil {
+		c.fail(err)
+		return false
+	}
+	snapshotRows(c.dbmap, dest, []interface{}{dest})
+	if hook, ok := dest.(HasPostGet); ok {
+		if err := hook.PostGet(c.exec); err != nil {
+			c.fail(err)
+			return false
+		}
+	}
+	return true
+}
+
+// mapColumns maps the columns of the query to the fields of t, unless
+// they were already mapped for the previous destination.
+func (c *Cursor) mapColumns(dest interface{}, t reflect.Type) error {
+	tableName := ""
+	if dyn, ok := dest.(DynamicTable); ok {
+		tableName = dyn.TableName()
+	}
+	if t == c.t && tableName == c.tableName {
+		return nil
+	}
+
+	c.t, c.tableName, c.colToFieldIndex = t, tableName, nil
+	c.intoStruct = t.Kind() == reflect.Struct
+	if !c.intoStruct {
+		if len(c.cols) > 1 {
+			return fmt.Errorf("gorp: select into non-struct requires 1 column, got %d", len(c.cols))
+		}
+		return nil
+	}
+
+	var err error
+	c.colToFieldIndex, err = columnToFieldIndex(c.dbmap, t, tableName, c.cols)
+	if err != nil {
+		if !NonFatalError(err) {
+			return err
+		}
+		c.nonFatalErr = err
+	}
+	return nil
+}
+
+// Err returns the error that ended the iteration, if any.  As with
+// Select, columns without a matching field are reported with a non-fatal
+// error; see NonFatalError.
+func (c *Cursor) Err() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.nonFatalErr
+}
+
+// Close closes the Cursor.  It is safe to call Close more than once.
+func (c *Cursor) Close() error {
+	if c.rows == nil {
+		return nil
+	}
+	err := c.rows.Close()
+	c.rows = nil
+	return err
+}
+
+// fail records err, if not nil, and closes the Cursor
+func (c *Cursor) fail(err error) {
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	c.Close()
+}
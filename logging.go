@@ -4,7 +4,11 @@
 
 package gorp
 
-import "fmt"
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+)
 
 // GorpLogger is a deprecated alias of Logger.
 type GorpLogger = Logger
@@ -40,3 +44,88 @@ func (m *DbMap) TraceOff() {
 	m.logger = nil
 	m.logPrefix = ""
 }
+
+// queryLogger is implemented by Loggers taking the statement, arguments
+// and duration of a log line as separate values rather than a formatted
+// string, such as SlogLogger.
+type queryLogger interface {
+	logQuery(prefix, query string, args []interface{}, d time.Duration)
+}
+
+// redacted replaces the value of sensitive arguments in logs.
+const redacted = "[redacted]"
+
+// SensitiveValue is a bind argument whose value is masked in the
+// statements logged by TraceOn and passed to interceptors.  It is
+// unwrapped before the statement is run.  See Sensitive.
+type SensitiveValue struct {
+	v interface{}
+}
+
+// Sensitive wraps the bind argument v so that its value is masked in the
+// SQL log and in the QueryEvents passed to interceptors.  gorp wraps the
+// values of the columns marked with ColumnMap.SetSensitive itself; use
+// Sensitive for the arguments of hand-written SQL.
+//
+// Example:
+//
+//	dbmap.Exec("update users set password = ? where id = ?",
+//		gorp.Sensitive(hash), id)
+func Sensitive(v interface{}) SensitiveValue {
+	if s, ok := v.(SensitiveValue); ok {
+		return s
+	}
+	return SensitiveValue{v}
+}
+
+// Unwrap returns the wrapped argument.
+func (s SensitiveValue) Unwrap() interface{} {
+	return s.v
+}
+
+// String returns a mask, so that formatting s does not reveal its value.
+func (s SensitiveValue) String() string {
+	return redacted
+}
+
+// GoString returns a mask, so that formatting s with %#v does not reveal
+// its value.
+func (s SensitiveValue) GoString() string {
+	return redacted
+}
+
+// Value implements driver.Valuer, for drivers given s without gorp
+// unwrapping it first, such as through a prepared statement.
+func (s SensitiveValue) Value() (driver.Value, error) {
+	return driver.DefaultParameterConverter.ConvertValue(s.v)
+}
+
+// RedactArgs returns args with the values wrapped by Sensitive replaced
+// by a mask, for logging them.
+func RedactArgs(args []interface{}) []interface{} {
+	return replaceSensitive(args, func(SensitiveValue) interface{} { return redacted })
+}
+
+// unwrapArgs returns args with the values wrapped by Sensitive unwrapped,
+// to be run.
+func unwrapArgs(args []interface{}) []interface{} {
+	return replaceSensitive(args, SensitiveValue.Unwrap)
+}
+
+// replaceSensitive returns args with the values wrapped by Sensitive
+// replaced by f, or args itself if there are none.
+func replaceSensitive(args []interface{}, f func(SensitiveValue) interface{}) []interface{} {
+	var replaced []interface{}
+	for i, a := range args {
+		if s, ok := a.(SensitiveValue); ok {
+			if replaced == nil {
+				replaced = append([]interface{}(nil), args...)
+			}
+			replaced[i] = f(s)
+		}
+	}
+	if replaced == nil {
+		return args
+	}
+	return replaced
+}
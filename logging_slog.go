@@ -0,0 +1,88 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package gorp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// SlogLogger is a Logger writing the SQL statements traced by TraceOn as
+// structured records to a slog.Handler.  Each record has the message
+// "sql" and the attributes "query", "args" and "duration", plus "prefix"
+// if TraceOn was given one.  The values of sensitive columns are masked
+// in args; see ColumnMap.SetSensitive.
+//
+// Example:
+//
+//	dbmap.TraceOn("", &gorp.SlogLogger{Handler: slog.Default().Handler()})
+type SlogLogger struct {
+	Handler slog.Handler
+
+	// Level is the level of the records, slog.LevelInfo by default.
+	Level slog.Level
+}
+
+// TraceOnSlog turns on SQL statement logging to h at slog.LevelInfo.  It
+// is a shorthand for TraceOn("", &SlogLogger{Handler: h}).
+func (m *DbMap) TraceOnSlog(h slog.Handler) {
+	m.TraceOn("", &SlogLogger{Handler: h})
+}
+
+// Printf implements Logger, logging the formatted message.
+func (l *SlogLogger) Printf(format string, v ...interface{}) {
+	l.log(fmt.Sprintf(format, v...))
+}
+
+func (l *SlogLogger) logQuery(prefix, query string, args []interface{}, d time.Duration) {
+	attrs := make([]slog.Attr, 0, 4)
+	if prefix = strings.TrimSpace(prefix); prefix != "" {
+		attrs = append(attrs, slog.String("prefix", prefix))
+	}
+	attrs = append(attrs,
+		slog.String("query", query),
+		slog.Any("args", logArgs(args)),
+		slog.Duration("duration", d))
+	l.log("sql", attrs...)
+}
+
+func (l *SlogLogger) log(msg string, attrs ...slog.Attr) {
+	ctx := context.Background()
+	if !l.Handler.Enabled(ctx, l.Level) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(4, pcs[:]) // skip Callers, log, logQuery and trace
+	r := slog.NewRecord(time.Now(), l.Level, msg, pcs[0])
+	r.AddAttrs(attrs...)
+	_ = l.Handler.Handle(ctx, r)
+}
+
+// logArgs returns the values of args as logged: masked if sensitive, and
+// converted by their driver.Valuer if they have one.
+func logArgs(args []interface{}) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, a := range args {
+		if _, ok := a.(SensitiveValue); ok {
+			values[i] = redacted
+		} else {
+			values[i] = argValue(a)
+		}
+	}
+	return values
+}
+
+// LogValue implements slog.LogValuer, so that logging s does not reveal
+// its value.
+func (s SensitiveValue) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
@@ -0,0 +1,67 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build integration && go1.21
+// +build integration,go1.21
+
+package gorp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/go-gorp/gorp/v3"
+)
+
+func TestSlogLogger(t *testing.T) {
+	dbmap := initAccountTable(t)
+	defer dropAndClose(dbmap)
+
+	buf := &bytes.Buffer{}
+	dbmap.TraceOn("acct", &gorp.SlogLogger{
+		Handler: slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}),
+		Level:   slog.LevelDebug,
+	})
+	_insert(dbmap, &Account{Name: "ann", Password: "hunter2", Token: "tok-secret"})
+
+	var record struct {
+		Level    string
+		Msg      string
+		Prefix   string
+		Query    string
+		Args     []interface{}
+		Duration int64
+	}
+	line, _, _ := strings.Cut(buf.String(), "\n")
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatalf("Expected a JSON record, got %q: %v", line, err)
+	}
+	if record.Level != "DEBUG" || record.Msg != "sql" || record.Prefix != "acct" {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if !strings.Contains(strings.ToLower(record.Query), "insert into") {
+		t.Errorf("Expected the insert statement, got %q", record.Query)
+	}
+	want := []interface{}{"ann", "[redacted]", "[redacted]"}
+	if len(record.Args) < len(want) {
+		t.Fatalf("Expected args %v, got %v", want, record.Args)
+	}
+	// the args may start with the default value of the key on some dialects
+	if got := record.Args[len(record.Args)-3:]; got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("Expected args %v, got %v", want, got)
+	}
+	if strings.Contains(buf.String(), "hunter2") || strings.Contains(buf.String(), "tok-secret") {
+		t.Errorf("Expected sensitive values to be masked, got %s", buf.String())
+	}
+
+	buf.Reset()
+	dbmap.TraceOn("", &gorp.SlogLogger{Handler: slog.NewJSONHandler(buf, nil), Level: slog.LevelDebug})
+	_insert(dbmap, &Account{Name: "bea"})
+	if buf.Len() != 0 {
+		t.Errorf("Expected records below the handler level to be dropped, got %s", buf.String())
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MigrationsTable is the name of the table in which Migrate records
+// applied migrations.
+const MigrationsTable = "gorp_migrations"
+
+// MigrationsLock is the name of the advisory lock held while migrations
+// run.
+const MigrationsLock = "gorp_migrations"
+
+// Migration is a versioned pair of SQL scripts.
+type Migration struct {
+	Version int64
+	Name    string
+
+	// Up applies the migration and Down reverts it.  Each is run as a
+	// single Exec, so a script of several statements requires a driver
+	// that supports them, e.g. MySQL with multiStatements=true.
+	Up   string
+	Down string
+}
+
+// MigrationRecord is a row of the MigrationsTable.
+type MigrationRecord struct {
+	Version   int64     `db:"version,primarykey"`
+	Name      string    `db:"name,size:255"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// LoadMigrations reads the migrations in directory dir of fsys.  Files
+// are named <version>_<name>.up.sql and <version>_<name>.down.sql, where
+// version is an integer; other files are ignored.  A migration without a
+// down script cannot be rolled back.  The migrations are returned in
+// version order.
+//
+// Example:
+//
+//	//go:embed migrations
+//	var migrations embed.FS
+//
+//	list, err := gorp.LoadMigrations(migrations, "migrations")
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		fname := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(fname, ".sql") {
+			continue
+		}
+		base := strings.TrimSuffix(fname, ".sql")
+		var up bool
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			up = true
+			base = strings.TrimSuffix(base, ".up")
+		case strings.HasSuffix(base, ".down"):
+			base = strings.TrimSuffix(base, ".down")
+		default:
+			continue
+		}
+		v, name := base, ""
+		if i := strings.IndexByte(base, '_'); i >= 0 {
+			v, name = base[:i], base[i+1:]
+		}
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("gorp: invalid migration version in %s", fname)
+		}
+
+		script, err := fs.ReadFile(fsys, path.Join(dir, fname))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("gorp: migration version %d used by %s and %s", version, m.Name, name)
+		}
+		if up {
+			m.Up = string(script)
+		} else {
+			m.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("gorp: migration %d_%s has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrate applies the migrations that have not been recorded in the
+// MigrationsTable yet, in version order.  Each migration runs in its own
+// Transaction together with its record, so a failing migration leaves the
+// ones before it applied.  The table is created if needed.
+//
+// If the Dialect implements AdvisoryLocker, Migrate holds the
+// MigrationsLock while it runs so that concurrent processes don't apply
+// the same migrations.  The lock takes a connection of the pool of Db
+// for itself, so Migrate returns an error if SetMaxOpenConns limited
+// the pool to one connection.
+func (m *DbMap) Migrate(ctx context.Context, migrations []Migration) error {
+	return m.withMigrationsLock(ctx, func(applied map[int64]bool) error {
+		for _, mig := range sortedMigrations(migrations) {
+			if applied[mig.Version] {
+				continue
+			}
+			record := &MigrationRecord{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now().UTC()}
+			if err := m.runMigration(ctx, mig.Up, func(tx SqlExecutor) error {
+				bi, err := m.migrationsTable().bindInsert(reflect.ValueOf(record).Elem())
+				if err != nil {
+					return err
+				}
+				_, err = tx.Exec(bi.query, bi.args...)
+				return err
+			}); err != nil {
+				return fmt.Errorf("gorp: migration %d_%s: %v", mig.Version, mig.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// RollbackMigrations runs the down scripts of the applied migrations with
+// a version greater than target, newest first, and deletes their records.
+// Rolling back to 0 reverts every migration.  It takes the same lock as
+// Migrate.
+func (m *DbMap) RollbackMigrations(ctx context.Context, migrations []Migration, target int64) error {
+	byVersion := make(map[int64]Migration, len(migrations))
+	for _, mig := range migrations {
+		byVersion[mig.Version] = mig
+	}
+	return m.withMigrationsLock(ctx, func(applied map[int64]bool) error {
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			if v > target {
+				versions = append(versions, v)
+			}
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, v := range versions {
+			mig, ok := byVersion[v]
+			if !ok {
+				return fmt.Errorf("gorp: no migration found for applied version %d", v)
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("gorp: migration %d_%s has no down script", mig.Version, mig.Name)
+			}
+			if err := m.runMigration(ctx, mig.Down, func(tx SqlExecutor) error {
+				bi, err := m.migrationsTable().bindDelete(reflect.ValueOf(&MigrationRecord{Version: v}).Elem())
+				if err != nil {
+					return err
+				}
+				_, err = tx.Exec(bi.query, bi.args...)
+				return err
+			}); err != nil {
+				return fmt.Errorf("gorp: rollback of migration %d_%s: %v", mig.Version, mig.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// AppliedMigrations returns the records of the applied migrations in
+// version order.
+func (m *DbMap) AppliedMigrations() ([]MigrationRecord, error) {
+	table := m.migrationsTable()
+	if _, err := m.Exec(table.SqlForCreate(true)); err != nil {
+		return nil, err
+	}
+	var records []MigrationRecord
+	query := fmt.Sprintf("select * from %s order by %s",
+		m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName), m.Dialect.QuoteField("version"))
+	_, err := onPrimary(m).Select(&records, query)
+	return records, err
+}
+
+// migrationsTable returns the TableMap of the MigrationsTable, which is
+// not registered on m; see bookkeepingTable.
+func (m *DbMap) migrationsTable() *TableMap {
+	return m.bookkeepingTable(MigrationRecord{}, MigrationsTable)
+}
+
+// withMigrationsLock calls fn with the versions of the applied migrations
+// while holding the MigrationsLock.
+func (m *DbMap) withMigrationsLock(ctx context.Context, fn func(applied map[int64]bool) error) error {
+	return m.withAdvisoryLock(ctx, MigrationsLock, func() error {
+		records, err := m.AppliedMigrations()
+		if err != nil {
+			return err
+		}
+		applied := make(map[int64]bool, len(records))
+		for _, r := range records {
+			applied[r.Version] = true
+		}
+		return fn(applied)
+	})
+}
+
+// withAdvisoryLock calls fn while holding the advisory lock named name,
+// if the Dialect implements AdvisoryLocker.  The lock is held on a
+// connection of its own while fn runs on the others of the pool, so it
+// fails rather than deadlocks if the pool is limited to one connection.
+func (m *DbMap) withAdvisoryLock(ctx context.Context, name string, fn func() error) (err error) {
+	if locker, ok := m.Dialect.(AdvisoryLocker); ok {
+		if m.Db.Stats().MaxOpenConnections == 1 {
+			return fmt.Errorf("gorp: holding lock %s needs a second connection, but MaxOpenConns of the DB is 1", name)
+		}
+		var conn *sql.Conn
+		conn, err = m.Db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err = locker.AdvisoryLock(ctx, conn, name); err != nil {
+			return err
+		}
+		defer func() {
+			// release the lock even if ctx has been cancelled
+			if uerr := locker.AdvisoryUnlock(context.Background(), conn, name); err == nil {
+				err = uerr
+			}
+		}()
+	}
+	return fn()
+}
+
+// runMigration runs script and then record in a single transaction
+func (m *DbMap) runMigration(ctx context.Context, script string, record func(tx SqlExecutor) error) error {
+	tx, err := m.WithContext(ctx).(*DbMap).Begin()
+	if err != nil {
+		return err
+	}
+	exec := tx.WithContext(ctx)
+	if _, err := exec.Exec(script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(exec); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func sortedMigrations(migrations []Migration) []Migration {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
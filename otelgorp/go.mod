@@ -0,0 +1,20 @@
+module github.com/go-gorp/gorp/v3/otelgorp
+
+go 1.18
+
+require (
+	github.com/go-gorp/gorp/v3 v3.1.0
+	github.com/mattn/go-sqlite3 v1.14.15
+	go.opentelemetry.io/otel v1.11.1
+	go.opentelemetry.io/otel/sdk v1.11.1
+	go.opentelemetry.io/otel/trace v1.11.1
+)
+
+// otelgorp is developed against the gorp module of this repository.
+replace github.com/go-gorp/gorp/v3 => ../
+
+require (
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+)
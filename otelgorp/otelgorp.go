@@ -0,0 +1,229 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package otelgorp traces the database calls of a gorp DbMap with
+// OpenTelemetry.
+//
+// Instrument adds an interceptor to a DbMap creating a span for each call
+// made by Insert, Update, Delete, Get, Select, Exec and the other methods
+// of the DbMap and its Transactions, and a span for each Transaction,
+// from Begin to Commit or Rollback.  Spans are children of the span in
+// the context given to WithContext, and the spans of the calls made in a
+// Transaction are children of its span.
+//
+// The db.rows_affected attribute is only set on the spans of statements
+// run with Exec, such as those of Update and Delete, when the driver
+// reports it.  The number of rows read by Select, Get and other queries
+// is not recorded: their rows are scanned after the call has returned
+// and its span has ended.
+//
+// Example:
+//
+//	dbmap := &gorp.DbMap{Db: db, Dialect: gorp.PostgresDialect{}}
+//	otelgorp.Instrument(dbmap)
+//
+//	ctx, span := tracer.Start(ctx, "checkout")
+//	defer span.End()
+//	err := dbmap.WithContext(ctx).Insert(order)
+package otelgorp
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+
+	"github.com/go-gorp/gorp/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/go-gorp/gorp/v3/otelgorp"
+
+// RowsAffectedKey is the attribute holding the number of rows affected by
+// a statement run with Exec, when the driver reports it.  It is not set
+// for queries.
+const RowsAffectedKey = attribute.Key("db.rows_affected")
+
+// IsolationLevelKey and ReadOnlyKey are the attributes holding the options
+// of a transaction begun with non-default ones; see gorp.DbMap.BeginTx.
+const (
+	IsolationLevelKey = attribute.Key("db.transaction.isolation_level")
+	ReadOnlyKey       = attribute.Key("db.transaction.read_only")
+)
+
+// Option configures the tracing of Instrument.
+type Option func(*tracer)
+
+// WithTracerProvider sets the TracerProvider creating the spans.  It
+// defaults to the global one returned by otel.GetTracerProvider.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(t *tracer) {
+		t.provider = tp
+	}
+}
+
+// WithAttributes adds attributes to all spans, such as the name of the
+// database.
+func WithAttributes(attrs ...attribute.KeyValue) Option {
+	return func(t *tracer) {
+		t.attrs = append(t.attrs, attrs...)
+	}
+}
+
+// WithoutStatement leaves the SQL statement out of the spans.  Bind
+// arguments are never recorded.
+func WithoutStatement() Option {
+	return func(t *tracer) {
+		t.noStatement = true
+	}
+}
+
+// Instrument adds an interceptor to m tracing its database calls.  Add it
+// before other interceptors so that their work is part of the spans.
+func Instrument(m *gorp.DbMap, opts ...Option) {
+	t := &tracer{provider: otel.GetTracerProvider()}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.tracer = t.provider.Tracer(instrumentationName)
+	t.attrs = append([]attribute.KeyValue{dbSystem(m.Dialect)}, t.attrs...)
+	m.AddInterceptors(t)
+}
+
+type tracer struct {
+	provider    trace.TracerProvider
+	tracer      trace.Tracer
+	attrs       []attribute.KeyValue
+	noStatement bool
+
+	// txSpans holds the spans of the open Transactions by their *sql.Tx,
+	// which the copies of a Transaction made with WithContext share.
+	txSpans sync.Map
+}
+
+// Intercept implements gorp.Interceptor.
+func (t *tracer) Intercept(ev *gorp.QueryEvent, next func() error) error {
+	switch ev.Op {
+	case gorp.OpBegin:
+		return t.begin(ev, next)
+	case gorp.OpCommit, gorp.OpRollback:
+		return t.end(ev, next)
+	}
+
+	attrs := append([]attribute.KeyValue{}, t.attrs...)
+	if ev.Table != "" {
+		attrs = append(attrs, semconv.DBSQLTableKey.String(ev.Table))
+	}
+	if !t.noStatement {
+		attrs = append(attrs, semconv.DBStatementKey.String(ev.Query))
+	}
+	if op := operation(ev.Query); op != "" {
+		attrs = append(attrs, semconv.DBOperationKey.String(op))
+	}
+	_, span := t.tracer.Start(ev.Context, spanName(ev),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	defer span.End()
+
+	err := next()
+	if ev.RowsAffected >= 0 {
+		span.SetAttributes(RowsAffectedKey.Int64(ev.RowsAffected))
+	}
+	setError(span, err)
+	return err
+}
+
+// begin starts the span of a Transaction, and has the Transaction run
+// with it.
+func (t *tracer) begin(ev *gorp.QueryEvent, next func() error) error {
+	attrs := append([]attribute.KeyValue{}, t.attrs...)
+	if opts := ev.TxOptions; opts != nil {
+		if opts.Isolation != sql.LevelDefault {
+			attrs = append(attrs, IsolationLevelKey.String(opts.Isolation.String()))
+		}
+		attrs = append(attrs, ReadOnlyKey.Bool(opts.ReadOnly))
+	}
+	ctx, span := t.tracer.Start(ev.Context, "gorp.Transaction",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	ev.Context = ctx
+	err := next()
+	if err != nil || ev.Tx == nil {
+		setError(span, err)
+		span.End()
+		return err
+	}
+	t.txSpans.Store(ev.Tx, span)
+	return nil
+}
+
+// end ends the span of a Transaction on commit or rollback, whatever the
+// context it is committed or rolled back with.
+func (t *tracer) end(ev *gorp.QueryEvent, next func() error) error {
+	err := next()
+	if v, ok := t.txSpans.LoadAndDelete(ev.Tx); ok {
+		span := v.(trace.Span)
+		span.AddEvent(string(ev.Op))
+		setError(span, err)
+		span.End()
+	}
+	return err
+}
+
+func setError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// spanName returns the name of the span of ev: the gorp method making
+// the call, or the kind of call for SQL run with Exec and the like.
+func spanName(ev *gorp.QueryEvent) string {
+	if ev.Method != "" {
+		return "gorp." + ev.Method
+	}
+	switch ev.Op {
+	case gorp.OpExec:
+		return "gorp.Exec"
+	case gorp.OpQuery:
+		return "gorp.Query"
+	case gorp.OpQueryRow:
+		return "gorp.QueryRow"
+	case gorp.OpPrepare:
+		return "gorp.Prepare"
+	}
+	return "gorp." + string(ev.Op)
+}
+
+// operation returns the first keyword of query, such as "select".
+func operation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimRight(fields[0], ";"))
+}
+
+// dbSystem returns the db.system attribute of dialect d.
+func dbSystem(d gorp.Dialect) attribute.KeyValue {
+	switch d.(type) {
+	case gorp.SqliteDialect, *gorp.SqliteDialect:
+		return semconv.DBSystemSqlite
+	case gorp.MySQLDialect, *gorp.MySQLDialect:
+		return semconv.DBSystemMySQL
+	case gorp.PostgresDialect, *gorp.PostgresDialect:
+		return semconv.DBSystemPostgreSQL
+	case gorp.OracleDialect, *gorp.OracleDialect:
+		return semconv.DBSystemOracle
+	case gorp.SqlServerDialect, *gorp.SqlServerDialect:
+		return semconv.DBSystemMSSQL
+	case gorp.SnowflakeDialect, *gorp.SnowflakeDialect:
+		return semconv.DBSystemKey.String("snowflake")
+	}
+	return semconv.DBSystemOtherSQL
+}
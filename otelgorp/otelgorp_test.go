@@ -0,0 +1,207 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !integration
+// +build !integration
+
+package otelgorp_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/go-gorp/gorp/v3/otelgorp"
+	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type Person struct {
+	Id   int64
+	Name string
+}
+
+func newDbMap(t *testing.T) (*gorp.DbMap, *tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	dbmap := &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}}
+	dbmap.AddTableWithName(Person{}, "person").SetKeys(true, "Id")
+	if err := dbmap.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otelgorp.Instrument(dbmap, otelgorp.WithTracerProvider(tp))
+	return dbmap, recorder, tp
+}
+
+func attrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestSpans(t *testing.T) {
+	dbmap, recorder, tp := newDbMap(t)
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	exec := dbmap.WithContext(ctx)
+	p := &Person{Name: "Ann"}
+	if err := exec.Insert(p); err != nil {
+		t.Fatal(err)
+	}
+	p.Name = "Bea"
+	if _, err := exec.Update(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.Get(Person{}, p.Id); err != nil {
+		t.Fatal(err)
+	}
+	var people []Person
+	if _, err := exec.Select(&people, "select * from person"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.Delete(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.Exec("delete from person where Id = ?", 42); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	want := []string{"gorp.Insert", "gorp.Update", "gorp.Get", "gorp.Select", "gorp.Delete", "gorp.Exec", "parent"}
+	if len(spans) != len(want) {
+		t.Fatalf("got %d spans, want %d", len(spans), len(want))
+	}
+	for i, span := range spans {
+		if span.Name() != want[i] {
+			t.Errorf("span %d: got name %q, want %q", i, span.Name(), want[i])
+		}
+		if i == len(want)-1 {
+			break
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s: not a child of the span of the context", span.Name())
+		}
+		a := attrs(span)
+		if a["db.system"].AsString() != "sqlite" {
+			t.Errorf("%s: got db.system %q", span.Name(), a["db.system"].AsString())
+		}
+		if a["db.statement"].AsString() == "" {
+			t.Errorf("%s: no db.statement", span.Name())
+		}
+	}
+
+	insert := attrs(spans[0])
+	if got := insert["db.sql.table"].AsString(); got != "person" {
+		t.Errorf("got db.sql.table %q, want person", got)
+	}
+	if got := insert["db.operation"].AsString(); got != "insert" {
+		t.Errorf("got db.operation %q, want insert", got)
+	}
+	if got := attrs(spans[1])[otelgorp.RowsAffectedKey].AsInt64(); got != 1 {
+		t.Errorf("got %d rows affected by the update, want 1", got)
+	}
+	if got := attrs(spans[5])[otelgorp.RowsAffectedKey].AsInt64(); got != 0 {
+		t.Errorf("got %d rows affected by the exec, want 0", got)
+	}
+	if _, ok := attrs(spans[5])["db.sql.table"]; ok {
+		t.Errorf("exec has a db.sql.table")
+	}
+}
+
+func TestTransactionSpans(t *testing.T) {
+	dbmap, recorder, _ := newDbMap(t)
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Insert(&Person{Name: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = dbmap.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("insert into nowhere values (1)"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	want := []string{"gorp.Insert", "gorp.Transaction", "gorp.Exec", "gorp.Transaction"}
+	if len(spans) != len(want) {
+		t.Fatalf("got %d spans, want %d", len(spans), len(want))
+	}
+	for i, span := range spans {
+		if span.Name() != want[i] {
+			t.Errorf("span %d: got name %q, want %q", i, span.Name(), want[i])
+		}
+	}
+	for _, pair := range [][2]int{{0, 1}, {2, 3}} {
+		child, tx := spans[pair[0]], spans[pair[1]]
+		if child.Parent().SpanID() != tx.SpanContext().SpanID() {
+			t.Errorf("%s: not a child of its transaction", child.Name())
+		}
+	}
+	if spans[2].Status().Code != codes.Error {
+		t.Errorf("failed exec: got status %v, want an error", spans[2].Status().Code)
+	}
+	if events := spans[1].Events(); len(events) != 1 || events[0].Name != "commit" {
+		t.Errorf("got events %v, want commit", events)
+	}
+	if events := spans[3].Events(); len(events) != 1 || events[0].Name != "rollback" {
+		t.Errorf("got events %v, want rollback", events)
+	}
+	if _, ok := attrs(spans[1])[otelgorp.IsolationLevelKey]; ok {
+		t.Errorf("default transaction has an isolation level")
+	}
+	if got := attrs(spans[3])[otelgorp.IsolationLevelKey].AsString(); got != "Serializable" {
+		t.Errorf("got isolation level %q, want Serializable", got)
+	}
+	if got, ok := attrs(spans[3])[otelgorp.ReadOnlyKey]; !ok || got.AsBool() {
+		t.Errorf("got read only %v, want false", got)
+	}
+}
+
+func TestTransactionSpanWithContext(t *testing.T) {
+	dbmap, recorder, _ := newDbMap(t)
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the copy runs with a context that doesn't carry the span
+	copy := tx.WithContext(context.Background()).(*gorp.Transaction)
+	if err := copy.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "gorp.Transaction" {
+		t.Fatalf("got %d spans, want the transaction", len(spans))
+	}
+	if events := spans[0].Events(); len(events) != 1 || events[0].Name != "commit" {
+		t.Errorf("got events %v, want commit", events)
+	}
+}
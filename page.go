@@ -0,0 +1,198 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Page identifies a page of the rows of a query for SelectPage.
+type Page struct {
+	// Number is the number of the page, starting at 1
+	Number int
+
+	// Size is the maximum number of rows in a page
+	Size int
+}
+
+// SelectPage runs query limited to the rows of page; see the SelectPage
+// function.  It is not part of SqlExecutor: hooks call the function.
+func (m *DbMap) SelectPage(i interface{}, query string, page Page, args ...interface{}) ([]interface{}, int64, error) {
+	return SelectPage(m, i, query, page, args...)
+}
+
+// SelectPage runs query limited to the rows of page with e, a DbMap or a
+// Transaction, using the paging syntax of the Dialect, and returns the
+// rows as Select does together with the total number of rows returned by
+// query.  The query should have an order by clause so that pages are
+// stable; dialects paging with "offset ... fetch next", such as
+// SqlServerDialect, require one.
+func SelectPage(e SqlExecutor, i interface{}, query string, page Page, args ...interface{}) ([]interface{}, int64, error) {
+	m := extractDbMap(e)
+	if m == nil {
+		return nil, 0, fmt.Errorf("gorp: SelectPage needs a DbMap or a Transaction, got %T", e)
+	}
+	if m.ExpandSliceArgs {
+		expandSliceArgs(&query, args...)
+	}
+
+	return selectPage(m, e, i, query, page, args...)
+}
+
+func selectPage(m *DbMap, exec SqlExecutor, i interface{}, query string, page Page, args ...interface{}) ([]interface{}, int64, error) {
+	if page.Number < 1 || page.Size < 1 {
+		return nil, 0, fmt.Errorf("gorp: invalid page %d of size %d", page.Number, page.Size)
+	}
+	if len(args) == 1 {
+		query, args = maybeExpandNamedQuery(m, query, args)
+	}
+
+	total, err := SelectInt(exec, countSql(m.Dialect, query), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	list, err := hookedselect(m, exec, i, pageSql(m.Dialect, query, page.Size, (page.Number-1)*page.Size), args...)
+	return list, total, err
+}
+
+// SelectKeyset runs the query for the page of at most size rows following
+// cursor, and returns the rows together with the cursor of the next page.
+// An empty cursor selects the first page; the returned cursor is empty
+// when there are no more rows.
+//
+// Unlike paging with an offset, keyset (or seek) pagination stays stable
+// when rows are inserted or deleted between pages, and does not slow down
+// on later pages.  Rows are sorted by the keys given to OrderBy followed
+// by the primary key of the table, and the cursor holds these values for
+// the last row of the page.  The sort columns must not be null.  Limit and
+// Offset are ignored.
+//
+// Cursors are opaque tokens, safe to pass to clients.  They are only
+// valid for a query with the same sort keys.
+func (q *Query) SelectKeyset(cursor string, size int) ([]interface{}, string, error) {
+	if q.err != nil {
+		return nil, "", q.err
+	}
+	if size < 1 {
+		return nil, "", fmt.Errorf("gorp: invalid page size %d", size)
+	}
+	if len(q.table.keys) == 0 {
+		return nil, "", fmt.Errorf("gorp: no keys defined for table: %s", q.table.TableName)
+	}
+
+	kq := *q
+	kq.orderBy = append([]queryOrder{}, q.orderBy...)
+	for _, key := range q.table.keys {
+		found := false
+		for _, order := range kq.orderBy {
+			found = found || order.col == key
+		}
+		if !found {
+			kq.orderBy = append(kq.orderBy, queryOrder{col: key})
+		}
+	}
+
+	if cursor != "" {
+		values, err := kq.decodeCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		kq.where = append(kq.where[:len(kq.where):len(kq.where)], kq.seekCondition())
+		kq.args = append(kq.args[:len(kq.args):len(kq.args)], seekArgs(values)...)
+	}
+
+	// one extra row tells whether there is a next page
+	kq.limit, kq.offset = size+1, -1
+	list, err := kq.Select()
+	if err != nil || len(list) <= size {
+		return list, "", err
+	}
+	list = list[:size]
+	next, err := kq.encodeCursor(list[size-1])
+	return list, next, err
+}
+
+// seekCondition returns the condition selecting the rows sorted after the
+// ones whose sort keys are equal to the bind variables.  For sort keys a,
+// b and c it is
+//
+//	(a > ?) or (a = ? and b > ?) or (a = ? and b = ? and c > ?)
+//
+// with < in place of > for descending keys.
+func (q *Query) seekCondition() string {
+	var terms []string
+	for i, order := range q.orderBy {
+		var term []string
+		for _, prev := range q.orderBy[:i] {
+			term = append(term, q.dbmap.Dialect.QuoteField(prev.col.ColumnName)+" = ?")
+		}
+		op := " > ?"
+		if order.desc {
+			op = " < ?"
+		}
+		term = append(term, q.dbmap.Dialect.QuoteField(order.col.ColumnName)+op)
+		terms = append(terms, "("+strings.Join(term, " and ")+")")
+	}
+	return "(" + strings.Join(terms, " or ") + ")"
+}
+
+// seekArgs returns the arguments of seekCondition for the sort key values
+func seekArgs(values []interface{}) []interface{} {
+	var args []interface{}
+	for i := range values {
+		args = append(args, values[:i+1]...)
+	}
+	return args
+}
+
+func (q *Query) encodeCursor(row interface{}) (string, error) {
+	elem := reflect.Indirect(reflect.ValueOf(row))
+	values := make([]interface{}, len(q.orderBy))
+	for i, order := range q.orderBy {
+		values[i] = elem.FieldByName(order.col.fieldName).Interface()
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+var errInvalidCursor = errors.New("gorp: invalid cursor")
+
+// decodeCursor returns the sort key values held by cursor, decoded to the
+// types of their fields and converted by the TypeConverter.
+func (q *Query) decodeCursor(cursor string) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil || len(raw) != len(q.orderBy) {
+		return nil, errInvalidCursor
+	}
+
+	t := q.table.gotype
+	values := make([]interface{}, len(raw))
+	for i, order := range q.orderBy {
+		f, _ := t.FieldByName(order.col.fieldName)
+		v := reflect.New(f.Type)
+		if err := json.Unmarshal(raw[i], v.Interface()); err != nil {
+			return nil, errInvalidCursor
+		}
+		values[i] = v.Elem().Interface()
+		if conv := q.dbmap.TypeConverter; conv != nil {
+			if values[i], err = conv.ToDb(values[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,368 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// queryOps are the comparison operators accepted by Query.Where
+var queryOps = map[string]bool{
+	"=": true, "<>": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true,
+	"like": true, "not like": true, "in": true, "not in": true,
+}
+
+// queryOrder is a sort key of a Query
+type queryOrder struct {
+	col  *ColumnMap
+	desc bool
+}
+
+// Query builds a select statement against a mapped table.  Field names
+// passed to its methods are resolved to column names through the
+// TableMap, so they keep working when a column is renamed with
+// ColumnMap.Rename.  Queries are created with DbMap.From or
+// Transaction.From.
+//
+// Example:
+//
+//	list, err := dbmap.From(&Invoice{}).
+//		Where("PersonId", "=", 7).
+//		Where("Memo", "like", "paid%").
+//		OrderBy("Created desc").
+//		Limit(10).
+//		Select()
+type Query struct {
+	dbmap  *DbMap
+	exec   SqlExecutor
+	table  *TableMap
+	holder interface{}
+
+	where   []string
+	args    []interface{}
+	orderBy []queryOrder
+	limit   int
+	offset  int
+	preload []string
+
+	// err is the first error met while building the query.  It is
+	// returned by the method running the query.
+	err error
+}
+
+// From starts a Query against the table mapped to i, which must be a
+// pointer to a mapped struct.  Rows are returned as values of the same
+// type.
+func (m *DbMap) From(i interface{}) *Query {
+	return newQuery(m, m, i)
+}
+
+// From has the same behavior as DbMap.From(), but runs in a transaction.
+func (t *Transaction) From(i interface{}) *Query {
+	return newQuery(t.dbmap, t, i)
+}
+
+func newQuery(m *DbMap, exec SqlExecutor, i interface{}) *Query {
+	q := &Query{dbmap: m, exec: exec, holder: i, limit: -1, offset: -1}
+	q.table, _, q.err = m.tableForPointer(i, false)
+	return q
+}
+
+// WithContext sets the context the query runs with.
+func (q *Query) WithContext(ctx context.Context) *Query {
+	q.exec = q.exec.WithContext(ctx)
+	return q
+}
+
+// Where adds a condition comparing the column mapped to field with value.
+// Conditions are joined with "and".  op is one of =, <>, !=, <, <=, >, >=,
+// like, not like, in and not in.  For in and not in, value must be a
+// slice.  A nil value with = or <> tests for null.
+func (q *Query) Where(field, op string, value interface{}) *Query {
+	col, ok := q.column(field)
+	if !ok {
+		return q
+	}
+	op = strings.ToLower(strings.Join(strings.Fields(op), " "))
+	if !queryOps[op] {
+		q.setErr(fmt.Errorf("gorp: unsupported operator %q in query on %s", op, q.table.TableName))
+		return q
+	}
+
+	switch {
+	case value == nil && (op == "=" || op == "<>" || op == "!="):
+		null := "is null"
+		if op != "=" {
+			null = "is not null"
+		}
+		q.where = append(q.where, fmt.Sprintf("%s %s", col, null))
+	case op == "in" || op == "not in":
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice {
+			q.setErr(fmt.Errorf("gorp: %s on %s.%s requires a slice, got %T", op, q.table.TableName, field, value))
+			return q
+		}
+		if v.Len() == 0 {
+			// an empty list matches no rows, and its negation all of them
+			if op == "in" {
+				q.where = append(q.where, "1=0")
+			}
+			return q
+		}
+		q.where = append(q.where, fmt.Sprintf("%s %s (%s)", col, op, strings.Repeat(",?", v.Len())[1:]))
+		for i := 0; i < v.Len(); i++ {
+			q.args = append(q.args, v.Index(i).Interface())
+		}
+	default:
+		q.where = append(q.where, fmt.Sprintf("%s %s ?", col, op))
+		q.args = append(q.args, value)
+	}
+	return q
+}
+
+// OrderBy adds sort keys to the query.  Each key is a field name,
+// optionally followed by "asc" or "desc".
+func (q *Query) OrderBy(keys ...string) *Query {
+	if q.err != nil {
+		return q
+	}
+	for _, key := range keys {
+		parts := strings.Fields(key)
+		if len(parts) == 0 || len(parts) > 2 {
+			q.setErr(fmt.Errorf("gorp: invalid sort key %q in query on %s", key, q.table.TableName))
+			return q
+		}
+		col, ok := q.columnMap(parts[0])
+		if !ok {
+			return q
+		}
+		order := queryOrder{col: col}
+		if len(parts) == 2 {
+			dir := strings.ToLower(parts[1])
+			if dir != "asc" && dir != "desc" {
+				q.setErr(fmt.Errorf("gorp: invalid sort key %q in query on %s", key, q.table.TableName))
+				return q
+			}
+			order.desc = dir == "desc"
+		}
+		q.orderBy = append(q.orderBy, order)
+	}
+	return q
+}
+
+// Preload loads the named relations of the selected rows, as
+// DbMap.Preload does.
+func (q *Query) Preload(relations ...string) *Query {
+	q.preload = append(q.preload, relations...)
+	return q
+}
+
+// Limit sets the maximum number of rows returned.  Dialects paging with
+// "offset ... fetch next", such as SqlServerDialect, require the query to
+// have an OrderBy as well.
+func (q *Query) Limit(n int) *Query {
+	q.limit = n
+	return q
+}
+
+// Offset sets the number of rows skipped before rows are returned.
+func (q *Query) Offset(n int) *Query {
+	q.offset = n
+	return q
+}
+
+// Sql returns the select statement and its arguments.
+func (q *Query) Sql() (string, []interface{}, error) {
+	if q.err != nil {
+		return "", nil, q.err
+	}
+	dialect := q.dbmap.Dialect
+
+	s := bytes.Buffer{}
+	s.WriteString("select ")
+	x := 0
+	for _, col := range q.table.Columns {
+		if col.Transient {
+			continue
+		}
+		if x > 0 {
+			s.WriteString(",")
+		}
+		s.WriteString(dialect.QuoteField(col.ColumnName))
+		x++
+	}
+	s.WriteString(" from ")
+	s.WriteString(dialect.QuotedTableForQuery(q.table.SchemaName, q.table.TableName))
+	args, err := q.writeWhere(&s)
+	if err != nil {
+		return "", nil, err
+	}
+	for i, order := range q.orderBy {
+		if i == 0 {
+			s.WriteString(" order by ")
+		} else {
+			s.WriteString(", ")
+		}
+		s.WriteString(dialect.QuoteField(order.col.ColumnName))
+		if order.desc {
+			s.WriteString(" desc")
+		}
+	}
+	if q.limit < 0 && q.offset <= 0 {
+		s.WriteString(dialect.QuerySuffix())
+		return s.String(), args, nil
+	}
+	return pageSql(dialect, s.String(), q.limit, q.offset), args, nil
+}
+
+// Select runs the query and returns the rows as pointers to the type
+// passed to From.  PostGet hooks and the TypeConverter apply as with
+// DbMap.Select.
+func (q *Query) Select() ([]interface{}, error) {
+	query, args, err := q.Sql()
+	if err != nil {
+		return nil, err
+	}
+	list, err := hookedselect(q.dbmap, withCall(q.exec, q.table, "Select"), q.holder, query, args...)
+	if err != nil && !NonFatalError(err) {
+		return nil, err
+	}
+	if perr := preload(q.dbmap, q.exec, q.preload, list...); perr != nil {
+		if !NonFatalError(perr) {
+			return nil, perr
+		}
+		if err == nil {
+			err = perr
+		}
+	}
+	return list, err
+}
+
+// SelectInto runs the query and appends the rows to holder, which must be
+// a pointer to a slice of the type passed to From, or of pointers to it.
+func (q *Query) SelectInto(holder interface{}) error {
+	query, args, err := q.Sql()
+	if err != nil {
+		return err
+	}
+	_, err = hookedselect(q.dbmap, withCall(q.exec, q.table, "Select"), holder, query, args...)
+	if err != nil && !NonFatalError(err) {
+		return err
+	}
+	if perr := preload(q.dbmap, q.exec, q.preload, holder); perr != nil {
+		if !NonFatalError(perr) {
+			return perr
+		}
+		if err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+// SelectOne runs the query and scans its single row into holder, which
+// must be a pointer to the type passed to From.  As with
+// DbMap.SelectOne, it returns sql.ErrNoRows if there are no rows.
+func (q *Query) SelectOne(holder interface{}) error {
+	query, args, err := q.Sql()
+	if err != nil {
+		return err
+	}
+	if err := SelectOne(q.dbmap, q.exec, holder, query, args...); err != nil {
+		return err
+	}
+	return preload(q.dbmap, q.exec, q.preload, holder)
+}
+
+// Count returns the number of rows matching the conditions of the query,
+// ignoring its order, limit and offset.
+func (q *Query) Count() (int64, error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+	dialect := q.dbmap.Dialect
+	s := bytes.Buffer{}
+	s.WriteString("select count(*) from ")
+	s.WriteString(dialect.QuotedTableForQuery(q.table.SchemaName, q.table.TableName))
+	args, err := q.writeWhere(&s)
+	if err != nil {
+		return 0, err
+	}
+	s.WriteString(dialect.QuerySuffix())
+	return SelectInt(withCall(q.exec, q.table, "Count"), s.String(), args...)
+}
+
+// writeWhere writes the where clause, replacing the placeholders of the
+// conditions with the dialect's bind variables, and returns its arguments.
+// Soft deleted rows are skipped unless the executor is unscoped, and only
+// the rows of the tenant of the executor are matched if the table is
+// scoped by tenant.
+func (q *Query) writeWhere(s *bytes.Buffer) ([]interface{}, error) {
+	where, args := q.where, q.args
+	if q.table.filtered(q.exec) {
+		cond, condArgs := q.table.softDeleteCond(false)
+		where = append(where[:len(where):len(where)], cond)
+		args = append(args[:len(args):len(args)], condArgs...)
+	}
+	if q.table.tenant != nil {
+		tenantArgs, err := q.table.tenantArgs(q.exec)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where[:len(where):len(where)], q.dbmap.Dialect.QuoteField(q.table.tenant.ColumnName)+" = ?")
+		args = append(args[:len(args):len(args)], tenantArgs...)
+	}
+	if len(where) == 0 {
+		return args, nil
+	}
+	s.WriteString(" where ")
+	n := 0
+	for i, cond := range where {
+		if i > 0 {
+			s.WriteString(" and ")
+		}
+		parts := strings.Split(cond, "?")
+		for j, part := range parts {
+			if j > 0 {
+				s.WriteString(q.dbmap.Dialect.BindVar(n))
+				n++
+			}
+			s.WriteString(part)
+		}
+	}
+	return args, nil
+}
+
+// column returns the quoted column mapped to field, recording an error
+// if there is none.
+func (q *Query) column(field string) (string, bool) {
+	col, ok := q.columnMap(field)
+	if !ok {
+		return "", false
+	}
+	return q.dbmap.Dialect.QuoteField(col.ColumnName), true
+}
+
+func (q *Query) columnMap(field string) (*ColumnMap, bool) {
+	if q.err != nil {
+		return nil, false
+	}
+	col := colMapOrNil(q.table, field)
+	if col == nil || col.Transient {
+		q.setErr(fmt.Errorf("gorp: no field %s in table %s", field, q.table.TableName))
+		return nil, false
+	}
+	return col, true
+}
+
+func (q *Query) setErr(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+}
@@ -0,0 +1,420 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// RelationKind is the kind of a relationship between mapped tables.
+type RelationKind int
+
+const (
+	// HasOne relates a row to the single row of another table whose
+	// foreign key holds its primary key.
+	HasOne RelationKind = iota
+
+	// HasMany relates a row to the rows of another table whose foreign
+	// key holds its primary key.
+	HasMany
+
+	// BelongsTo relates a row to the row of another table whose primary
+	// key is held by its foreign key.
+	BelongsTo
+)
+
+// defaultPreloadChunk is the number of keys per "in" list when the dialect
+// does not report a limit on bind variables
+const defaultPreloadChunk = 1000
+
+// Relation is a relationship declared with TableMap.HasOne, HasMany or
+// BelongsTo.
+type Relation struct {
+	Kind RelationKind
+
+	// Field is the name of the struct field the related rows are loaded
+	// into.
+	Field string
+
+	// ForeignKey is the name of the field holding the foreign key.  It is
+	// a field of the related type for HasOne and HasMany, and of this
+	// table's type for BelongsTo.
+	ForeignKey string
+
+	// related is the type of the related struct
+	related reflect.Type
+}
+
+// HasOne declares that field, a struct or pointer to a struct mapped to
+// another table, holds the row of that table whose foreignKey field
+// references the primary key of this table.  field is made transient.
+//
+// Example:
+//
+//	dbmap.AddTable(Person{}).SetKeys(true, "Id").HasOne("Profile", "PersonId")
+func (t *TableMap) HasOne(field, foreignKey string) *TableMap {
+	return t.addRelation(HasOne, field, foreignKey)
+}
+
+// HasMany declares that field, a slice of structs or of pointers to
+// structs mapped to another table, holds the rows of that table whose
+// foreignKey field references the primary key of this table.  field is
+// made transient.
+//
+// Example:
+//
+//	dbmap.AddTable(Person{}).SetKeys(true, "Id").HasMany("Invoices", "PersonId")
+func (t *TableMap) HasMany(field, foreignKey string) *TableMap {
+	return t.addRelation(HasMany, field, foreignKey)
+}
+
+// BelongsTo declares that field, a struct or pointer to a struct mapped to
+// another table, holds the row of that table whose primary key is
+// referenced by the foreignKey field of this table.  field is made
+// transient.
+//
+// Example:
+//
+//	dbmap.AddTable(Invoice{}).SetKeys(true, "Id").BelongsTo("Person", "PersonId")
+func (t *TableMap) BelongsTo(field, foreignKey string) *TableMap {
+	return t.addRelation(BelongsTo, field, foreignKey)
+}
+
+// Relations returns the relationships declared on this table.
+func (t *TableMap) Relations() []Relation {
+	return append([]Relation{}, t.relations...)
+}
+
+func (t *TableMap) addRelation(kind RelationKind, field, foreignKey string) *TableMap {
+	f, ok := t.gotype.FieldByName(field)
+	if !ok {
+		panic(fmt.Sprintf("No field %s in table %s type %s", field, t.TableName, t.gotype.Name()))
+	}
+	related := f.Type
+	if kind == HasMany {
+		if related.Kind() != reflect.Slice {
+			panic(fmt.Sprintf("gorp: has-many field %s of %s is not a slice", field, t.gotype.Name()))
+		}
+		related = related.Elem()
+	}
+	if related.Kind() == reflect.Ptr {
+		related = related.Elem()
+	}
+	if related.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("gorp: relation field %s of %s does not hold structs", field, t.gotype.Name()))
+	}
+
+	fkType := related
+	if kind == BelongsTo {
+		fkType = t.gotype
+	}
+	if _, ok := fkType.FieldByName(foreignKey); !ok {
+		panic(fmt.Sprintf("gorp: no foreign key field %s in type %s", foreignKey, fkType.Name()))
+	}
+
+	if col := colMapOrNil(t, field); col != nil {
+		col.SetTransient(true)
+	}
+	t.relations = append(t.relations, Relation{Kind: kind, Field: field, ForeignKey: foreignKey, related: related})
+	return t
+}
+
+func (t *TableMap) relation(field string) (Relation, error) {
+	for _, r := range t.relations {
+		if r.Field == field {
+			return r, nil
+		}
+	}
+	return Relation{}, fmt.Errorf("gorp: no relation %s on table %s", field, t.TableName)
+}
+
+// Preloader runs Select and Get and then loads the given relations of the
+// returned rows, with one query per relation.  It is created with
+// DbMap.Preload or Transaction.Preload.  Nil rows are skipped.  As with
+// Select, a non-fatal error scanning the related rows, such as a column
+// missing from their type, doesn't stop the loading: it is returned once
+// all relations are loaded.
+type Preloader struct {
+	dbmap     *DbMap
+	exec      SqlExecutor
+	relations []string
+}
+
+// Preload returns a Preloader loading the named relations, as declared
+// with TableMap.HasOne, HasMany or BelongsTo, of the rows it selects.
+// Instead of a query per row, each relation is loaded by a single
+// "select ... where key in (...)" query over the keys of all rows.
+//
+// Example:
+//
+//	var people []Person
+//	_, err := dbmap.Preload("Invoices").Select(&people, "select * from person")
+func (m *DbMap) Preload(relations ...string) *Preloader {
+	return &Preloader{dbmap: m, exec: m, relations: relations}
+}
+
+// Preload has the same behavior as DbMap.Preload(), but runs in a transaction.
+func (t *Transaction) Preload(relations ...string) *Preloader {
+	return &Preloader{dbmap: t.dbmap, exec: t, relations: relations}
+}
+
+// Select has the same behavior as DbMap.Select(), and then loads the
+// relations of the rows.
+func (p *Preloader) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	list, err := p.exec.Select(i, query, args...)
+	if err != nil && !NonFatalError(err) {
+		return nil, err
+	}
+	// as in hookedselect, rows are either written to i or returned
+	rows := list
+	if t, _ := toSliceType(i); t != nil {
+		rows = []interface{}{i}
+	}
+	if perr := p.Load(rows...); perr != nil {
+		if !NonFatalError(perr) {
+			return nil, perr
+		}
+		if err == nil {
+			err = perr
+		}
+	}
+	return list, err
+}
+
+// Get has the same behavior as DbMap.Get(), and then loads the relations
+// of the row.
+func (p *Preloader) Get(i interface{}, keys ...interface{}) (interface{}, error) {
+	obj, err := p.exec.Get(i, keys...)
+	if err != nil || obj == nil {
+		return obj, err
+	}
+	return obj, p.Load(obj)
+}
+
+// Load loads the relations into rows that have already been selected.
+// Each element of list is a pointer to a mapped struct or a pointer to a
+// slice of them; all rows must be of the same type.
+func (p *Preloader) Load(list ...interface{}) error {
+	return preload(p.dbmap, p.exec, p.relations, list...)
+}
+
+func preload(m *DbMap, exec SqlExecutor, relations []string, list ...interface{}) error {
+	if len(relations) == 0 {
+		return nil
+	}
+
+	var rows []reflect.Value
+	for _, item := range list {
+		v := reflect.ValueOf(item)
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			return fmt.Errorf("gorp: cannot preload into non-pointer %T", item)
+		}
+		v = reflect.Indirect(v.Elem())
+		if !v.IsValid() {
+			continue
+		}
+		if v.Kind() == reflect.Slice {
+			for x := 0; x < v.Len(); x++ {
+				// skip the nil elements of a []*T
+				if row := reflect.Indirect(v.Index(x)); row.IsValid() {
+					rows = append(rows, row)
+				}
+			}
+			continue
+		}
+		rows = append(rows, v)
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	table, err := m.TableFor(rows[0].Type(), false)
+	if err != nil {
+		return err
+	}
+	var nonFatal error
+	for _, name := range relations {
+		r, err := table.relation(name)
+		if err != nil {
+			return err
+		}
+		err = loadRelation(m, exec, table, r, rows)
+		if err != nil && !NonFatalError(err) {
+			return err
+		}
+		if nonFatal == nil {
+			nonFatal = err
+		}
+	}
+	return nonFatal
+}
+
+// loadRelation selects the rows related to rows through r and assigns
+// them to the relation field of each row.  It returns the first non-fatal
+// error of the selects once the rows are assigned.
+func loadRelation(m *DbMap, exec SqlExecutor, table *TableMap, r Relation, rows []reflect.Value) error {
+	related, err := m.TableFor(r.related, false)
+	if err != nil {
+		return err
+	}
+
+	// the key of rows matched against the key column of the related table
+	ownKey, relatedKey := "", ""
+	if r.Kind == BelongsTo {
+		pk, err := singleKey(related)
+		if err != nil {
+			return err
+		}
+		ownKey, relatedKey = r.ForeignKey, pk.fieldName
+	} else {
+		pk, err := singleKey(table)
+		if err != nil {
+			return err
+		}
+		ownKey, relatedKey = pk.fieldName, r.ForeignKey
+	}
+	keyCol := colMapOrNil(related, relatedKey)
+	if keyCol == nil {
+		return fmt.Errorf("gorp: no field %s in table %s", relatedKey, related.TableName)
+	}
+
+	var keys []interface{}
+	seen := make(map[interface{}]bool)
+	for _, row := range rows {
+		k, ok := relationKey(row.FieldByName(ownKey))
+		if ok && !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+
+	// related rows by the value of their key field
+	byKey := make(map[interface{}][]reflect.Value)
+	var nonFatal error
+	tenantArgs, err := related.tenantArgs(exec)
+	if err != nil {
+		return err
+	}
+	chunk := defaultPreloadChunk
+	// keep room for the soft delete and tenant arguments
+	if b, ok := m.Dialect.(BatchInserter); ok && b.MaxBindVars()-2 < chunk {
+		chunk = b.MaxBindVars() - 2
+	}
+	for len(keys) > 0 {
+		n := chunk
+		if n > len(keys) {
+			n = len(keys)
+		}
+		s := bytes.Buffer{}
+		s.WriteString(fmt.Sprintf("select * from %s where %s in (",
+			m.Dialect.QuotedTableForQuery(related.SchemaName, related.TableName),
+			m.Dialect.QuoteField(keyCol.ColumnName)))
+		for x := 0; x < n; x++ {
+			if x > 0 {
+				s.WriteString(",")
+			}
+			s.WriteString(m.Dialect.BindVar(x))
+		}
+		s.WriteString(")")
+		args := keys[:n:n]
+		if related.filtered(exec) {
+			cond, condArgs := related.softDeleteCond(false)
+			s.WriteString(" and ")
+			s.WriteString(strings.Replace(cond, "?", m.Dialect.BindVar(n), 1))
+			args = append(args, condArgs...)
+		}
+		related.writeTenantCond(&s, len(args))
+		args = append(args, tenantArgs...)
+		s.WriteString(m.Dialect.QuerySuffix())
+
+		list, err := hookedselect(m, withCall(exec, related, "Select"), reflect.New(r.related).Interface(), s.String(), args...)
+		if err != nil && !NonFatalError(err) {
+			return err
+		}
+		if nonFatal == nil {
+			nonFatal = err
+		}
+		for _, item := range list {
+			v := reflect.ValueOf(item).Elem()
+			if k, ok := relationKey(v.FieldByName(relatedKey)); ok {
+				byKey[k] = append(byKey[k], v)
+			}
+		}
+		keys = keys[n:]
+	}
+
+	for _, row := range rows {
+		f := row.FieldByName(r.Field)
+		k, ok := relationKey(row.FieldByName(ownKey))
+		var matches []reflect.Value
+		if ok {
+			matches = byKey[k]
+		}
+
+		if r.Kind == HasMany {
+			s := reflect.MakeSlice(f.Type(), 0, len(matches))
+			for _, v := range matches {
+				s = reflect.Append(s, relatedValue(f.Type().Elem(), v))
+			}
+			f.Set(s)
+			continue
+		}
+		if len(matches) == 0 {
+			f.Set(reflect.Zero(f.Type()))
+			continue
+		}
+		f.Set(relatedValue(f.Type(), matches[0]))
+	}
+	return nonFatal
+}
+
+// relatedValue returns the struct v as a value of type t, which is either
+// the struct type or a pointer to it.
+func relatedValue(t reflect.Type, v reflect.Value) reflect.Value {
+	if t.Kind() == reflect.Ptr {
+		return v.Addr()
+	}
+	return v
+}
+
+func singleKey(t *TableMap) (*ColumnMap, error) {
+	if len(t.keys) != 1 {
+		return nil, fmt.Errorf("gorp: relations require a single column primary key on table %s", t.TableName)
+	}
+	return t.keys[0], nil
+}
+
+// relationKey returns the value of a key field in a form that compares
+// equal across integer types, and false if the key is null.
+func relationKey(v reflect.Value) (interface{}, bool) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		val, err := valuer.Value()
+		if err != nil || val == nil {
+			return nil, false
+		}
+		v = reflect.ValueOf(val)
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), true
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), true
+		}
+	}
+	return v.Interface(), true
+}
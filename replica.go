@@ -0,0 +1,226 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaPolicy selects the replica a read is sent to.
+type ReplicaPolicy int
+
+const (
+	// RoundRobin sends reads to each healthy replica in turn.
+	RoundRobin ReplicaPolicy = iota
+
+	// LeastLatency sends reads to the healthy replica that answered the
+	// health checks the fastest.  Until the first health check, it sends
+	// them to the first replica.
+	LeastLatency
+)
+
+// Replicas routes the reads of a DbMap to read replicas of its Db, set
+// on DbMap.Replicas.
+//
+// The queries run with Select, SelectOne, SelectInt and the like, Get,
+// Query and QueryRow on the DbMap are sent to a replica if they are
+// SELECT statements.  Other statements, statements run with Exec or
+// Prepare, and everything run in a Transaction go to the primary, as do
+// reads with a context returned by UsePrimary, such as reads that must
+// see a write that replication may not have caught up with yet.
+//
+// Replicas failing a health check (see CheckHealth) receive no reads until
+// they pass one again.  Reads go to the primary when no replica is
+// healthy.
+//
+// Get only fills the Cache with rows read from the primary, and Migrate,
+// ApplySchema and PlanSchema always read from the primary, as do the
+// inserts reading the id they generate.  A statement is sent to a replica
+// if it starts with "select", so SELECT statements with side effects,
+// such as "select nextval('seq')", must be run with UsePrimary.
+type Replicas struct {
+	Policy ReplicaPolicy
+
+	replicas []*replica
+	next     uint32 // the next replica of RoundRobin
+}
+
+type replica struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	unhealthy bool
+	latency   time.Duration // moving average of the health checks
+}
+
+// NewReplicas returns Replicas sending reads to dbs according to policy.
+func NewReplicas(policy ReplicaPolicy, dbs ...*sql.DB) *Replicas {
+	r := &Replicas{Policy: policy}
+	for _, db := range dbs {
+		r.replicas = append(r.replicas, &replica{db: db})
+	}
+	return r
+}
+
+// CheckHealth pings each replica, marking those that fail unhealthy until
+// they succeed again, and records the latency of those that succeed for
+// LeastLatency.  It returns the number of healthy replicas.
+func (r *Replicas) CheckHealth(ctx context.Context) int {
+	var wg sync.WaitGroup
+	for _, rep := range r.replicas {
+		wg.Add(1)
+		go func(rep *replica) {
+			defer wg.Done()
+			start := time.Now()
+			err := rep.db.PingContext(ctx)
+			d := time.Since(start)
+
+			rep.mu.Lock()
+			defer rep.mu.Unlock()
+			rep.unhealthy = err != nil
+			if err == nil {
+				if rep.latency == 0 {
+					rep.latency = d
+				} else {
+					rep.latency = (rep.latency*3 + d) / 4
+				}
+			}
+		}(rep)
+	}
+	wg.Wait()
+
+	healthy := 0
+	for _, rep := range r.replicas {
+		if ok, _ := rep.health(); ok {
+			healthy++
+		}
+	}
+	return healthy
+}
+
+// StartHealthChecks runs CheckHealth every interval, each check timing
+// out after interval, until the returned function is called.
+func (r *Replicas) StartHealthChecks(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				r.CheckHealth(ctx)
+				cancel()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (rep *replica) health() (bool, time.Duration) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return !rep.unhealthy, rep.latency
+}
+
+// pick returns the replica to send a read to, or nil if none is healthy.
+func (r *Replicas) pick() *sql.DB {
+	n := len(r.replicas)
+	if n == 0 {
+		return nil
+	}
+	if r.Policy == LeastLatency {
+		var best *replica
+		var bestLatency time.Duration
+		for _, rep := range r.replicas {
+			if ok, latency := rep.health(); ok && (best == nil || latency < bestLatency) {
+				best, bestLatency = rep, latency
+			}
+		}
+		if best == nil {
+			return nil
+		}
+		return best.db
+	}
+
+	start := int(atomic.AddUint32(&r.next, 1)-1) % n
+	for i := 0; i < n; i++ {
+		rep := r.replicas[(start+i)%n]
+		if ok, _ := rep.health(); ok {
+			return rep.db
+		}
+	}
+	return nil
+}
+
+// primaryKey is the context key of UsePrimary.
+type primaryKey struct{}
+
+// UsePrimary returns a copy of ctx whose reads go to the primary database
+// rather than to a replica.  Use it with DbMap.WithContext for reads that
+// must see the writes made just before.
+//
+// Example:
+//
+//	dbmap.Insert(inv)
+//	obj, err := dbmap.WithContext(gorp.UsePrimary(ctx)).Get(Invoice{}, inv.Id)
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// usesPrimary reports whether ctx was returned by UsePrimary.
+func usesPrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// reader returns the executor running query, read from a replica if m
+// has one it can send it to, or from the primary.
+func (m *DbMap) reader(query string) executor {
+	if m.Replicas == nil || usesPrimary(m.ctx) || !isSelect(query) {
+		return m.Db
+	}
+	if db := m.Replicas.pick(); db != nil {
+		return db
+	}
+	return m.Db
+}
+
+// onPrimary returns a copy of exec reading from the primary database.
+func onPrimary(exec SqlExecutor) SqlExecutor {
+	if !readsReplica(exec) {
+		return exec
+	}
+	m := exec.(*DbMap)
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return m.WithContext(UsePrimary(ctx))
+}
+
+// readsReplica reports whether the reads of exec may go to a replica.
+func readsReplica(exec SqlExecutor) bool {
+	m, ok := exec.(*DbMap)
+	return ok && m.Replicas != nil && !usesPrimary(m.ctx)
+}
+
+// isSelect reports whether query is a SELECT statement, which may be
+// read from a replica.
+func isSelect(query string) bool {
+	q := strings.TrimLeft(query, " \t\r\n(")
+	return len(q) >= 6 && strings.EqualFold(q[:6], "select")
+}
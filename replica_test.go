@@ -0,0 +1,228 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !integration
+// +build !integration
+
+package gorp_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-gorp/gorp/v3"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type ReplicatedPerson struct {
+	Id   int64
+	Name string
+}
+
+// openReplica opens a SQLite database in dir holding a single person
+// named name.
+func openReplica(t *testing.T, dir, name string) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(dir, name+".db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dbmap := &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}}
+	dbmap.AddTableWithName(ReplicatedPerson{}, "person").SetKeys(false, "Id")
+	if err := dbmap.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbmap.Insert(&ReplicatedPerson{1, name}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestReplicas(t *testing.T) {
+	dir := t.TempDir()
+	primary := openReplica(t, dir, "primary")
+	r1, r2 := openReplica(t, dir, "r1"), openReplica(t, dir, "r2")
+
+	dbmap := &gorp.DbMap{Db: primary, Dialect: gorp.SqliteDialect{}}
+	dbmap.AddTableWithName(ReplicatedPerson{}, "person").SetKeys(false, "Id")
+	dbmap.Replicas = gorp.NewReplicas(gorp.RoundRobin, r1, r2)
+
+	get := func(exec gorp.SqlExecutor) string {
+		t.Helper()
+		obj, err := exec.Get(ReplicatedPerson{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj.(*ReplicatedPerson).Name
+	}
+	selectName := func(exec gorp.SqlExecutor) string {
+		t.Helper()
+		name, err := exec.SelectStr("select Name from person where Id = 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	if got := []string{get(dbmap), get(dbmap), selectName(dbmap), selectName(dbmap)}; got[0] != "r1" || got[1] != "r2" || got[2] != "r1" || got[3] != "r2" {
+		t.Errorf("Expected reads to alternate between the replicas, got %v", got)
+	}
+	if got := get(dbmap.WithContext(gorp.UsePrimary(context.Background()))); got != "primary" {
+		t.Errorf("Expected UsePrimary to read from the primary, got %s", got)
+	}
+
+	if _, err := dbmap.Update(&ReplicatedPerson{1, "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	if err := primary.QueryRow("select count(*) from person where Name = 'updated'").Scan(&n); err != nil || n != 1 {
+		t.Errorf("Expected the update to be written to the primary, got %d rows, %v", n, err)
+	}
+
+	tx, err := dbmap.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get(tx); got != "updated" {
+		t.Errorf("Expected a transaction to read from the primary, got %s", got)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	r1.Close()
+	if healthy := dbmap.Replicas.CheckHealth(context.Background()); healthy != 1 {
+		t.Errorf("Expected 1 healthy replica, got %d", healthy)
+	}
+	if got := []string{get(dbmap), get(dbmap)}; got[0] != "r2" || got[1] != "r2" {
+		t.Errorf("Expected reads to skip the unhealthy replica, got %v", got)
+	}
+	r2.Close()
+	if healthy := dbmap.Replicas.CheckHealth(context.Background()); healthy != 0 {
+		t.Errorf("Expected no healthy replica, got %d", healthy)
+	}
+	if got := get(dbmap); got != "updated" {
+		t.Errorf("Expected reads to fall back to the primary, got %s", got)
+	}
+}
+
+func TestReplicasLeastLatency(t *testing.T) {
+	dir := t.TempDir()
+	primary := openReplica(t, dir, "primary")
+	r1, r2 := openReplica(t, dir, "r1"), openReplica(t, dir, "r2")
+
+	dbmap := &gorp.DbMap{Db: primary, Dialect: gorp.SqliteDialect{}}
+	dbmap.AddTableWithName(ReplicatedPerson{}, "person").SetKeys(false, "Id")
+	dbmap.Replicas = gorp.NewReplicas(gorp.LeastLatency, r1, r2)
+	if healthy := dbmap.Replicas.CheckHealth(context.Background()); healthy != 2 {
+		t.Fatalf("Expected 2 healthy replicas, got %d", healthy)
+	}
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		obj, err := dbmap.Get(ReplicatedPerson{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, obj.(*ReplicatedPerson).Name)
+	}
+	if names[0] == "primary" || names[1] != names[0] || names[2] != names[0] {
+		t.Errorf("Expected reads to go to the fastest replica, got %v", names)
+	}
+}
+
+func TestReplicasReadOwnBookkeeping(t *testing.T) {
+	dir := t.TempDir()
+	primary := openReplica(t, dir, "primary")
+	replica := openReplica(t, dir, "replica") // has no migrations or schema versions
+
+	dbmap := &gorp.DbMap{Db: primary, Dialect: gorp.SqliteDialect{}}
+	dbmap.AddTableWithName(ReplicatedPerson{}, "person").SetKeys(false, "Id")
+	dbmap.Replicas = gorp.NewReplicas(gorp.RoundRobin, replica)
+	ctx := context.Background()
+
+	migrations := []gorp.Migration{{Version: 1, Name: "create", Up: "create table migrated (id integer)"}}
+	for i := 0; i < 2; i++ {
+		if err := dbmap.Migrate(ctx, migrations); err != nil {
+			t.Fatalf("Expected run %d of Migrate to succeed, got %v", i+1, err)
+		}
+	}
+	if applied, err := dbmap.AppliedMigrations(); err != nil || len(applied) != 1 {
+		t.Errorf("Expected 1 applied migration, got %v, %v", applied, err)
+	}
+
+	plan := &gorp.SchemaPlan{Changes: []gorp.SchemaChange{
+		{Kind: gorp.CreateTableChange, Table: "planned", Sql: "create table planned (id integer)"},
+	}}
+	for i := 0; i < 2; i++ {
+		if err := dbmap.ApplySchema("v1", plan); err != nil {
+			t.Fatalf("Expected run %d of ApplySchema to succeed, got %v", i+1, err)
+		}
+	}
+
+	// rows read from a replica may be stale, and are not cached
+	cache := gorp.NewLRUCache(10, 0)
+	dbmap.Cache = cache
+	obj, err := dbmap.Get(ReplicatedPerson{}, 1)
+	if err != nil || obj.(*ReplicatedPerson).Name != "replica" {
+		t.Fatalf("Expected to read the replica, got %v, %v", obj, err)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("Expected a row read from a replica not to be cached, got %d rows", cache.Len())
+	}
+	if _, err := dbmap.WithContext(gorp.UsePrimary(ctx)).Get(ReplicatedPerson{}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("Expected a row read from the primary to be cached, got %d rows", cache.Len())
+	}
+}
+
+// idQueryDialect reads generated ids with a select once the row is
+// inserted, as OracleDialect does.
+type idQueryDialect struct {
+	gorp.Dialect
+}
+
+func (d idQueryDialect) InsertQueryToTarget(exec gorp.SqlExecutor, insertSql, idSql string, target interface{}, params ...interface{}) error {
+	if _, err := exec.Exec(insertSql, params...); err != nil {
+		return err
+	}
+	id, err := exec.SelectInt(idSql)
+	if err != nil {
+		return err
+	}
+	*(target.(*int64)) = id
+	return nil
+}
+
+type ReplicatedCounter struct {
+	Id int64
+}
+
+func TestReplicasInsertReadsIdFromPrimary(t *testing.T) {
+	dir := t.TempDir()
+	primary := openReplica(t, dir, "primary")
+	replica := openReplica(t, dir, "replica") // has no counter table
+
+	dbmap := &gorp.DbMap{Db: primary, Dialect: idQueryDialect{gorp.SqliteDialect{}}}
+	dbmap.AddTableWithName(ReplicatedCounter{}, "counter").SetKeys(true, "Id").
+		ColMap("Id").GeneratedIdQuery = "select max(Id) from counter"
+	if err := dbmap.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	dbmap.Replicas = gorp.NewReplicas(gorp.RoundRobin, replica)
+
+	for i := int64(1); i <= 2; i++ {
+		c := &ReplicatedCounter{}
+		if err := dbmap.Insert(c); err != nil {
+			t.Fatalf("Expected the id to be read from the primary, got %v", err)
+		}
+		if c.Id != i {
+			t.Errorf("Expected id %d, got %d", i, c.Id)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+// RetryPolicy controls how RunInTx retries transactions failing with
+// retryable errors.
+type RetryPolicy struct {
+	// MaxRetries is the number of times a transaction is run again after
+	// its first attempt.  Zero turns off retrying.
+	MaxRetries int
+
+	// MinBackoff is the delay before the first retry.  Each retry waits
+	// twice as long as the previous one, up to MaxBackoff, minus a random
+	// jitter of up to half the delay so that conflicting transactions
+	// don't retry in lockstep.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is the RetryPolicy of a DbMap whose TxRetry is nil.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	MinBackoff: 10 * time.Millisecond,
+	MaxBackoff: time.Second,
+}
+
+// backoff returns the delay before the retry numbered retry, from 0.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.MinBackoff
+	for i := 0; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d - time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// RunInTx runs fn in a Transaction begun with ctx and opts, either of
+// which may be nil.  The transaction is committed if fn returns nil, and
+// rolled back if it returns an error or panics, in which case the error
+// is returned or the panic resumed.  fn must not commit or roll back the
+// transaction itself.
+//
+// If fn or the commit fails with an error the Dialect reports as
+// retryable (see RetryClassifier), such as a serialization failure or a
+// deadlock, the transaction is rolled back and fn is run again in a new
+// one, following the TxRetry policy of m.  fn must therefore be safe to
+// run more than once, and not have effects outside of the transaction.
+//
+// Example:
+//
+//	err := dbmap.RunInTx(ctx, nil, func(tx *gorp.Transaction) error {
+//		inv, err := tx.Get(Invoice{}, id)
+//		if err != nil {
+//			return err
+//		}
+//		inv.(*Invoice).Paid = true
+//		_, err = tx.Update(inv)
+//		return err
+//	})
+func (m *DbMap) RunInTx(ctx context.Context, opts *sql.TxOptions, fn func(tx *Transaction) error) error {
+	policy := DefaultRetryPolicy
+	if m.TxRetry != nil {
+		policy = *m.TxRetry
+	}
+	classifier, _ := m.Dialect.(RetryClassifier)
+	db := m
+	if ctx != nil {
+		db = m.WithContext(ctx).(*DbMap)
+	}
+
+	for retry := 0; ; retry++ {
+		err := db.runTx(opts, fn)
+		if err == nil || classifier == nil || retry >= policy.MaxRetries || !classifier.IsRetryable(err) {
+			return err
+		}
+		if err := sleep(ctx, policy.backoff(retry)); err != nil {
+			return err
+		}
+	}
+}
+
+// runTx runs fn once in a Transaction; see RunInTx.
+func (m *DbMap) runTx(opts *sql.TxOptions, fn func(tx *Transaction) error) error {
+	tx, err := m.beginTx(opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if ctx == nil {
+		time.Sleep(d)
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sqlState returns the SQLSTATE code of the first error in the chain of
+// err with a SQLState method.
+func sqlState(err error) (string, bool) {
+	var e interface{ SQLState() string }
+	if errors.As(err, &e) {
+		return e.SQLState(), true
+	}
+	return "", false
+}
+
+// errorCode returns the value of the integer field named field of the
+// first error struct in the chain of err that has one, so that driver
+// errors can be classified without importing the drivers.
+func errorCode(err error, field string) (int64, bool) {
+	for ; err != nil; err = errors.Unwrap(err) {
+		v := reflect.ValueOf(err)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		f := v.FieldByName(field)
+		switch f.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return f.Int(), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return int64(f.Uint()), true
+		}
+	}
+	return 0, false
+}
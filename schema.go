@@ -0,0 +1,358 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SchemaVersionsTable is the name of the bookkeeping table in which
+// ApplySchema records applied versions.
+const SchemaVersionsTable = "gorp_schema_versions"
+
+// SchemaVersion is a row of the SchemaVersionsTable.
+type SchemaVersion struct {
+	Version   string    `db:"version,primarykey,size:255"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// SchemaChangeKind identifies the kind of statement of a SchemaChange.
+type SchemaChangeKind string
+
+const (
+	CreateTableChange SchemaChangeKind = "create table"
+	AddColumnChange   SchemaChangeKind = "add column"
+	AlterColumnChange SchemaChangeKind = "alter column"
+	DropColumnChange  SchemaChangeKind = "drop column"
+	CreateIndexChange SchemaChangeKind = "create index"
+	DropIndexChange   SchemaChangeKind = "drop index"
+)
+
+// SchemaChange is a single statement of a SchemaPlan.
+type SchemaChange struct {
+	Kind SchemaChangeKind
+
+	// Table is the name of the table the change applies to
+	Table string
+
+	// Name is the column or index changed.  It is empty for
+	// CreateTableChange.
+	Name string
+
+	Sql string
+}
+
+// SchemaPlan is the ordered list of changes bringing the database schema
+// in line with the tables registered on a DbMap.  It is returned by
+// DbMap.PlanSchema and run by DbMap.ApplySchema.
+type SchemaPlan struct {
+	Changes []SchemaChange
+}
+
+// Sql returns the statements of the plan in order, for a dry run.
+func (p *SchemaPlan) Sql() []string {
+	stmts := make([]string, len(p.Changes))
+	for i, c := range p.Changes {
+		stmts[i] = c.Sql
+	}
+	return stmts
+}
+
+func (p *SchemaPlan) add(kind SchemaChangeKind, table, name, sql string) {
+	p.Changes = append(p.Changes, SchemaChange{Kind: kind, Table: table, Name: name, Sql: sql})
+}
+
+// PlanSchema compares the tables registered on this DbMap with the live
+// database catalog and returns the changes needed to bring the database
+// in line with them.  Column types come from Dialect.ToSqlType; not null,
+// unique and default settings of each ColumnMap and the indexes added with
+// AddIndex are compared as well.  Columns and indexes that exist in the
+// database but are not mapped are dropped, except for indexes backing
+// unique constraints.  Primary keys are not compared.
+//
+// For each table, the plan drops indexes first, then adds, alters and
+// drops columns, then creates indexes.  Missing tables are created with
+// SqlForCreate, tables referenced by foreign keys first.
+//
+// The Dialect must implement SchemaInspector and SchemaAlterer.
+func (m *DbMap) PlanSchema() (*SchemaPlan, error) {
+	inspector, ok := m.Dialect.(SchemaInspector)
+	if !ok {
+		return nil, fmt.Errorf("gorp: dialect %T does not implement SchemaInspector", m.Dialect)
+	}
+	alterer, ok := m.Dialect.(SchemaAlterer)
+	if !ok {
+		return nil, fmt.Errorf("gorp: dialect %T does not implement SchemaAlterer", m.Dialect)
+	}
+
+	// the catalog of a replica may not show the latest changes yet
+	exec := onPrimary(m)
+	plan := &SchemaPlan{}
+	for _, t := range m.tablesInDependencyOrder() {
+		if err := m.planTable(exec, plan, inspector, alterer, t); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// ApplySchema runs the statements of plan in a transaction and records
+// version in the SchemaVersionsTable, which is created if needed.  If
+// version has already been recorded, ApplySchema does nothing.  Like
+// Migrate, it holds the MigrationsLock while it runs if the Dialect
+// implements AdvisoryLocker, so that concurrent processes don't apply
+// the same plan, and so needs a pool of more than one connection.
+//
+// Note that some databases, such as MySQL, commit DDL statements
+// implicitly, so a failing plan may be partially applied.
+func (m *DbMap) ApplySchema(version string, plan *SchemaPlan) error {
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	table := m.bookkeepingTable(SchemaVersion{}, SchemaVersionsTable)
+	return m.withAdvisoryLock(ctx, MigrationsLock, func() error {
+		if _, err := m.Exec(table.SqlForCreate(true)); err != nil {
+			return err
+		}
+
+		query := fmt.Sprintf("select count(*) from %s where %s = %s",
+			m.Dialect.QuotedTableForQuery(table.SchemaName, table.TableName),
+			m.Dialect.QuoteField("version"), m.Dialect.BindVar(0))
+		n, err := SelectInt(onPrimary(m), query, version)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return nil
+		}
+
+		tx, err := m.Begin()
+		if err != nil {
+			return err
+		}
+		for _, c := range plan.Changes {
+			if _, err := tx.Exec(c.Sql); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("gorp: applying %s %s: %v", c.Kind, c.Table, err)
+			}
+		}
+		bi, err := table.bindInsert(reflect.ValueOf(&SchemaVersion{Version: version, AppliedAt: time.Now().UTC()}).Elem())
+		if err == nil {
+			_, err = tx.Exec(bi.query, bi.args...)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// bookkeepingMu guards the bookkeeping tables of every DbMap, which are
+// built by concurrent calls to Migrate and ApplySchema.
+var bookkeepingMu sync.Mutex
+
+// bookkeepingTable returns a TableMap named name for the rows of gorp's
+// own bookkeeping type i, built on first use and kept on m.  It is not
+// registered on m, so that DropTables, TruncateTables and PlanSchema
+// leave the table alone.
+func (m *DbMap) bookkeepingTable(i interface{}, name string) *TableMap {
+	bookkeepingMu.Lock()
+	defer bookkeepingMu.Unlock()
+	if table, ok := m.bookkeeping[name]; ok {
+		return table
+	}
+	t := reflect.TypeOf(i)
+	table := &TableMap{gotype: t, TableName: name, dbmap: m}
+	table.Columns, table.keys = m.readStructColumns(t)
+	if m.bookkeeping == nil {
+		m.bookkeeping = make(map[string]*TableMap)
+	}
+	m.bookkeeping[name] = table
+	return table
+}
+
+// schemaIndex is an index the planner expects to find on a table
+type schemaIndex struct {
+	index *IndexMap
+
+	// byName is true for indexes added with AddIndex, which are matched
+	// by name.  Unique constraints are matched by their columns.
+	byName bool
+}
+
+func (m *DbMap) planTable(exec SqlExecutor, plan *SchemaPlan, inspector SchemaInspector, alterer SchemaAlterer, t *TableMap) error {
+	dialect := reflect.TypeOf(m.Dialect)
+	quotedTable := m.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)
+
+	existing, err := inspector.TableColumns(exec, t.SchemaName, t.TableName)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		plan.add(CreateTableChange, t.TableName, "", t.SqlForCreate(false))
+		for _, idx := range t.indexes {
+			plan.add(CreateIndexChange, t.TableName, idx.IndexName, m.sqlForCreateIndex(dialect, t, idx))
+		}
+		return nil
+	}
+	indexes, err := inspector.TableIndexes(exec, t.SchemaName, t.TableName)
+	if err != nil {
+		return err
+	}
+
+	// indexes to create, and the catalog indexes that match them
+	var create []*IndexMap
+	matched := make([]bool, len(indexes))
+	for _, want := range t.schemaIndexes() {
+		found := -1
+		for i, have := range indexes {
+			if want.byName && strings.EqualFold(have.Name, want.index.IndexName) ||
+				!want.byName && have.Unique && equalFold(have.Columns, want.index.columns) {
+				found = i
+				break
+			}
+		}
+		if found >= 0 {
+			matched[found] = true
+			have := indexes[found]
+			if have.Unique == want.index.Unique && equalFold(have.Columns, want.index.columns) {
+				continue
+			}
+			// drop and recreate the index below
+			matched[found] = false
+		}
+		create = append(create, want.index)
+	}
+	for i, have := range indexes {
+		if !matched[i] && !have.Constraint {
+			plan.add(DropIndexChange, t.TableName, have.Name, t.sqlForDropIndex(have.Name))
+		}
+	}
+
+	byName := make(map[string]CatalogColumn, len(existing))
+	for _, col := range existing {
+		byName[strings.ToLower(col.Name)] = col
+	}
+	mapped := make(map[string]bool, len(t.Columns))
+	var alters []SchemaChange
+	for _, col := range t.Columns {
+		if col.Transient {
+			continue
+		}
+		mapped[strings.ToLower(col.ColumnName)] = true
+		sqlType := m.Dialect.ToSqlType(col.gotype, col.MaxSize, col.isAutoIncr)
+		notNull := col.isPK || col.isNotNull
+
+		have, ok := byName[strings.ToLower(col.ColumnName)]
+		if !ok {
+			def := fmt.Sprintf("%s %s", m.Dialect.QuoteField(col.ColumnName), sqlType)
+			if col.DefaultValue != "" {
+				def += " default " + col.DefaultValue
+			}
+			if notNull {
+				def += " not null"
+			}
+			plan.add(AddColumnChange, t.TableName, col.ColumnName, alterer.AddColumnSql(quotedTable, def))
+			continue
+		}
+		if col.isAutoIncr {
+			// autoincrement columns are reported under the type of
+			// their sequence or storage, so they are not compared
+			continue
+		}
+		if normalizeSqlType(have.Type) == normalizeSqlType(sqlType) &&
+			have.NotNull == notNull &&
+			(col.DefaultValue == "" || normalizeDefault(have.Default) == normalizeDefault(col.DefaultValue)) {
+			continue
+		}
+		stmts := alterer.AlterColumnSql(quotedTable, col.ColumnName, sqlType, notNull, col.DefaultValue)
+		if stmts == nil {
+			return fmt.Errorf("gorp: dialect %T cannot alter column %s.%s", m.Dialect, t.TableName, col.ColumnName)
+		}
+		for _, stmt := range stmts {
+			alters = append(alters, SchemaChange{AlterColumnChange, t.TableName, col.ColumnName, stmt})
+		}
+	}
+	plan.Changes = append(plan.Changes, alters...)
+	for _, col := range existing {
+		if !mapped[strings.ToLower(col.Name)] {
+			plan.add(DropColumnChange, t.TableName, col.Name, alterer.DropColumnSql(quotedTable, col.Name))
+		}
+	}
+
+	for _, idx := range create {
+		plan.add(CreateIndexChange, t.TableName, idx.IndexName, m.sqlForCreateIndex(dialect, t, idx))
+	}
+	return nil
+}
+
+// schemaIndexes returns the indexes added with AddIndex, followed by the
+// unique constraints of the table.  Unnamed unique constraints are given
+// a name derived from the table and column names.
+func (t *TableMap) schemaIndexes() []schemaIndex {
+	var indexes []schemaIndex
+	for _, idx := range t.indexes {
+		indexes = append(indexes, schemaIndex{index: idx, byName: true})
+	}
+	unique := func(name string, columns []string) {
+		if name == "" {
+			name = fmt.Sprintf("%s_%s_key", t.TableName, strings.Join(columns, "_"))
+		}
+		idx := &IndexMap{IndexName: name, Unique: true, columns: columns}
+		indexes = append(indexes, schemaIndex{index: idx})
+	}
+	for _, col := range t.Columns {
+		if col.Unique && !col.Transient {
+			unique("", []string{col.ColumnName})
+		}
+	}
+	for i, columns := range t.uniqueTogether {
+		unique(t.uniqueNames[i], columns)
+	}
+	return indexes
+}
+
+// normalizeSqlType brings column types reported by the catalog and
+// returned by ToSqlType to a comparable form.
+func normalizeSqlType(s string) string {
+	s = strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	s = strings.Replace(s, " (", "(", 1)
+	switch s {
+	case "serial":
+		return "integer"
+	case "bigserial":
+		return "bigint"
+	}
+	return s
+}
+
+// normalizeDefault strips whitespace and enclosing parentheses from a
+// column default.
+func normalizeDefault(s string) string {
+	s = strings.TrimSpace(s)
+	for len(s) > 1 && s[0] == '(' && s[len(s)-1] == ')' {
+		s = strings.TrimSpace(s[1 : len(s)-1])
+	}
+	return s
+}
+
+func equalFold(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -155,7 +155,7 @@ func selectVal(e SqlExecutor, holder interface{}, query string, args ...interfac
 			query, args = maybeExpandNamedQuery(m.dbmap, query, args)
 		}
 	}
-	rows, err := e.Query(query, args...)
+	rows, err := withCall(e, nil, "Select").Query(query, args...)
 	if err != nil {
 		return err
 	}
@@ -176,7 +176,7 @@ func hookedselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 
 	var nonFatalErr error
 
-	list, err := rawselect(m, exec, i, query, args...)
+	list, err := rawselect(m, withCall(exec, nil, "Select"), i, query, args...)
 	if err != nil {
 		if !NonFatalError(err) {
 			return nil, err
@@ -184,6 +184,8 @@ func hookedselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 		nonFatalErr = err
 	}
 
+	snapshotRows(m, i, list)
+
 	// Determine where the results are: written to i, or returned in list
 	if t, _ := toSliceType(i); t == nil {
 		for _, v := range list {
@@ -301,46 +303,11 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 			v.Interface().(DynamicTable).SetTableName(tableName)
 		}
 
-		dest := make([]interface{}, len(cols))
-
-		custScan := make([]CustomScanner, 0)
-
-		for x := range cols {
-			f := v.Elem()
-			if intoStruct {
-				index := colToFieldIndex[x]
-				if index == nil {
-					// this field is not present in the struct, so create a dummy
-					// value for rows.Scan to scan into
-					var dummy dummyField
-					dest[x] = &dummy
-					continue
-				}
-				f = f.FieldByIndex(index)
-			}
-			target := f.Addr().Interface()
-			if conv != nil {
-				scanner, ok := conv.FromDb(target)
-				if ok {
-					target = scanner.Holder
-					custScan = append(custScan, scanner)
-				}
-			}
-			dest[x] = target
-		}
-
-		err = rows.Scan(dest...)
+		err = scanRow(conv, rows, v.Elem(), len(cols), colToFieldIndex, intoStruct)
 		if err != nil {
 			return nil, err
 		}
 
-		for _, c := range custScan {
-			err = c.Bind()
-			if err != nil {
-				return nil, err
-			}
-		}
-
 		if appendToSlice {
 			if !pointerElements {
 				v = v.Elem()
@@ -357,3 +324,50 @@ func rawselect(m *DbMap, exec SqlExecutor, i interface{}, query string,
 
 	return list, nonFatalErr
 }
+
+// scanRow scans the current row of rows into v, which must be
+// addressable.  If intoStruct is true, each column is scanned into the
+// field of v given by colToFieldIndex; otherwise the single column is
+// scanned into v itself.
+func scanRow(conv TypeConverter, rows *sql.Rows, v reflect.Value, ncols int, colToFieldIndex [][]int, intoStruct bool) error {
+	dest := make([]interface{}, ncols)
+
+	custScan := make([]CustomScanner, 0)
+
+	for x := 0; x < ncols; x++ {
+		f := v
+		if intoStruct {
+			index := colToFieldIndex[x]
+			if index == nil {
+				// this field is not present in the struct, so create a dummy
+				// value for rows.Scan to scan into
+				var dummy dummyField
+				dest[x] = &dummy
+				continue
+			}
+			f = f.FieldByIndex(index)
+		}
+		target := f.Addr().Interface()
+		if conv != nil {
+			scanner, ok := conv.FromDb(target)
+			if ok {
+				target = scanner.Holder
+				custScan = append(custScan, scanner)
+			}
+		}
+		dest[x] = target
+	}
+
+	err := rows.Scan(dest...)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range custScan {
+		err = c.Bind()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
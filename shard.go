@@ -0,0 +1,336 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ShardFunc returns the index in ShardedDbMap.Shards of the shard holding
+// a row of table t.  row is the pointer to the row passed to Insert,
+// Update or Delete, or nil for Get, and keys holds the primary key values
+// of the row.
+type ShardFunc func(t *TableMap, row interface{}, keys []interface{}) (int, error)
+
+// ShardedDbMap maps tables to several databases with the same schema,
+// each holding part of the rows, such as the rows of some tenants.
+//
+// Tables are registered once on the ShardedDbMap, which shares them with
+// all of its shards.  The statements and values of a shared table are
+// built with the Dialect, TypeConverter and Now of the first shard, so
+// all shards must have the same ones.
+// Insert, Update, Delete and Get send each row to the shard returned by
+// ShardKey, and Select runs its query on all shards and merges the
+// results.  Use ShardOf or ShardOfKeys to run anything else, such as a
+// Transaction, on the shard of a row.  The methods of a shared TableMap
+// that run statements, such as DropIndex, run them on the first shard
+// only; ShardedDbMap has its own CreateIndex and DropIndex.
+//
+// Example:
+//
+//	sharded := gorp.NewShardedDbMap(func(t *gorp.TableMap, row interface{}, keys []interface{}) (int, error) {
+//		return int(keys[0].(int64) % 2), nil // the tenant of the row
+//	}, shard0, shard1)
+//	sharded.AddTableWithName(Invoice{}, "invoice").SetKeys(false, "TenantId", "Id")
+type ShardedDbMap struct {
+	Shards   []*DbMap
+	ShardKey ShardFunc
+}
+
+// NewShardedDbMap returns a ShardedDbMap over shards, routing rows with
+// shardKey.  Panics if the shards don't have the same Dialect,
+// TypeConverter and Now.
+func NewShardedDbMap(shardKey ShardFunc, shards ...*DbMap) *ShardedDbMap {
+	s := &ShardedDbMap{Shards: shards, ShardKey: shardKey}
+	s.checkShards()
+	return s
+}
+
+// checkShards panics if the shards can't share tables, as the Dialect,
+// TypeConverter or Now of a shard differ from those of the first one.
+func (s *ShardedDbMap) checkShards() {
+	if len(s.Shards) == 0 {
+		panic("gorp: ShardedDbMap has no shards")
+	}
+	first := s.Shards[0]
+	for i, shard := range s.Shards[1:] {
+		var differs string
+		switch {
+		case !reflect.DeepEqual(shard.Dialect, first.Dialect):
+			differs = "Dialect"
+		case !reflect.DeepEqual(shard.TypeConverter, first.TypeConverter):
+			differs = "TypeConverter"
+		case funcPointer(shard.Now) != funcPointer(first.Now):
+			differs = "Now"
+		}
+		if differs != "" {
+			panic(fmt.Sprintf("gorp: shard %d has a different %s than shard 0", i+1, differs))
+		}
+	}
+}
+
+// funcPointer returns the code pointer of fn, or 0 if fn is nil.
+func funcPointer(fn func() time.Time) uintptr {
+	if fn == nil {
+		return 0
+	}
+	return reflect.ValueOf(fn).Pointer()
+}
+
+// AddTable registers the type of i on all shards; see DbMap.AddTable.
+func (s *ShardedDbMap) AddTable(i interface{}) *TableMap {
+	return s.AddTableWithNameAndSchema(i, "", "")
+}
+
+// AddTableWithName registers the type of i on all shards; see
+// DbMap.AddTableWithName.
+func (s *ShardedDbMap) AddTableWithName(i interface{}, name string) *TableMap {
+	return s.AddTableWithNameAndSchema(i, "", name)
+}
+
+// AddTableWithNameAndSchema registers the type of i on all shards; see
+// DbMap.AddTableWithNameAndSchema.  The TableMap returned is shared by
+// the shards, so that the keys, columns and the like set on it apply to
+// all of them.  Dynamic tables are not shared.  Panics if the shards
+// don't have the same Dialect, TypeConverter and Now.
+func (s *ShardedDbMap) AddTableWithNameAndSchema(i interface{}, schema string, name string) *TableMap {
+	s.checkShards()
+	t := s.Shards[0].AddTableWithNameAndSchema(i, schema, name)
+	for _, shard := range s.Shards[1:] {
+		shard.shareTable(t)
+	}
+	return t
+}
+
+// shareTable registers t, a table of another DbMap, on m.
+func (m *DbMap) shareTable(t *TableMap) {
+	if _, found := m.tablesByType[t.gotype]; found {
+		return
+	}
+	m.tables = append(m.tables, t)
+	if m.tablesByType == nil {
+		m.tablesByType = make(map[reflect.Type]*TableMap)
+	}
+	m.tablesByType[t.gotype] = t
+}
+
+// CreateTablesIfNotExists creates the tables on all shards; see
+// DbMap.CreateTablesIfNotExists.
+func (s *ShardedDbMap) CreateTablesIfNotExists() error {
+	return s.each(func(shard *DbMap) error { return shard.CreateTablesIfNotExists() })
+}
+
+// DropTablesIfExists drops the tables on all shards; see
+// DbMap.DropTablesIfExists.
+func (s *ShardedDbMap) DropTablesIfExists() error {
+	return s.each(func(shard *DbMap) error { return shard.DropTablesIfExists() })
+}
+
+// CreateIndex creates the indexes of the tables on all shards; see
+// DbMap.CreateIndex.
+func (s *ShardedDbMap) CreateIndex() error {
+	return s.each(func(shard *DbMap) error { return shard.CreateIndex() })
+}
+
+// DropIndex drops the index named name of the shared table t on all
+// shards.  TableMap.DropIndex only drops it on the first shard, as t
+// belongs to that one.
+func (s *ShardedDbMap) DropIndex(t *TableMap, name string) error {
+	defer t.ResetSql()
+	for _, idx := range t.indexes {
+		if idx.IndexName == name {
+			return s.each(func(shard *DbMap) error {
+				_, err := shard.Exec(t.sqlForDropIndex(idx.IndexName))
+				return err
+			})
+		}
+	}
+	return nil
+}
+
+func (s *ShardedDbMap) each(fn func(shard *DbMap) error) error {
+	for i, shard := range s.Shards {
+		if err := fn(shard); err != nil {
+			return fmt.Errorf("gorp: shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ShardOf returns the shard holding row, a pointer to a struct of a
+// registered table.
+func (s *ShardedDbMap) ShardOf(row interface{}) (*DbMap, error) {
+	t, elem, err := s.Shards[0].tableForPointer(row, true)
+	if err != nil {
+		return nil, err
+	}
+	return s.shard(t, row, t.keyValues(elem))
+}
+
+// ShardOfKeys returns the shard holding the row of the table of i with
+// the primary key values keys, as given to Get.
+func (s *ShardedDbMap) ShardOfKeys(i interface{}, keys ...interface{}) (*DbMap, error) {
+	typ, err := toType(i)
+	if err != nil {
+		return nil, err
+	}
+	found, err := tableFor(s.Shards[0], typ, i)
+	if err != nil {
+		return nil, err
+	}
+	return s.shard(found.table, nil, keys)
+}
+
+func (s *ShardedDbMap) shard(t *TableMap, row interface{}, keys []interface{}) (*DbMap, error) {
+	n, err := s.ShardKey(t, row, keys)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n >= len(s.Shards) {
+		return nil, fmt.Errorf("gorp: shard %d of table %s out of range, there are %d shards", n, t.TableName, len(s.Shards))
+	}
+	return s.Shards[n], nil
+}
+
+// byShard groups list by the shard holding each row, in the order of the
+// shards.
+func (s *ShardedDbMap) byShard(list []interface{}) ([]*DbMap, [][]interface{}, error) {
+	groups := make(map[*DbMap][]interface{})
+	for _, row := range list {
+		shard, err := s.ShardOf(row)
+		if err != nil {
+			return nil, nil, err
+		}
+		groups[shard] = append(groups[shard], row)
+	}
+	var shards []*DbMap
+	var rows [][]interface{}
+	for _, shard := range s.Shards {
+		if group, ok := groups[shard]; ok {
+			shards = append(shards, shard)
+			rows = append(rows, group)
+		}
+	}
+	return shards, rows, nil
+}
+
+// Insert inserts each row of list in its shard; see DbMap.Insert.  The
+// rows of each shard are inserted separately, so an error may leave the
+// rows of other shards inserted.
+func (s *ShardedDbMap) Insert(list ...interface{}) error {
+	shards, rows, err := s.byShard(list)
+	if err != nil {
+		return err
+	}
+	for i, shard := range shards {
+		if err := shard.Insert(rows[i]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update updates each row of list in its shard; see DbMap.Update.  It
+// returns the number of rows updated in all shards.
+func (s *ShardedDbMap) Update(list ...interface{}) (int64, error) {
+	shards, rows, err := s.byShard(list)
+	if err != nil {
+		return -1, err
+	}
+	var count int64
+	for i, shard := range shards {
+		n, err := shard.Update(rows[i]...)
+		if err != nil {
+			return -1, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// Delete deletes each row of list from its shard; see DbMap.Delete.  It
+// returns the number of rows deleted from all shards.
+func (s *ShardedDbMap) Delete(list ...interface{}) (int64, error) {
+	shards, rows, err := s.byShard(list)
+	if err != nil {
+		return -1, err
+	}
+	var count int64
+	for i, shard := range shards {
+		n, err := shard.Delete(rows[i]...)
+		if err != nil {
+			return -1, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+// Get fetches the row with the primary key values keys from its shard;
+// see DbMap.Get.
+func (s *ShardedDbMap) Get(i interface{}, keys ...interface{}) (interface{}, error) {
+	shard, err := s.ShardOfKeys(i, keys...)
+	if err != nil {
+		return nil, err
+	}
+	return shard.Get(i, keys...)
+}
+
+// Select runs query on all shards concurrently and merges the results in
+// the order of the shards; see DbMap.Select.  Each shard applies the
+// ORDER BY, LIMIT and the like of query to its own rows only.  As with
+// DbMap.Select, a non-fatal error, such as a column missing from the
+// type, is returned along with the rows of all shards: the first one
+// reported by a shard is returned.
+func (s *ShardedDbMap) Select(i interface{}, query string, args ...interface{}) ([]interface{}, error) {
+	// each shard selects into its own slice, appended to i once all are done
+	var slice reflect.Value
+	if v := reflect.ValueOf(i); v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+		slice = v.Elem()
+	}
+
+	lists := make([][]interface{}, len(s.Shards))
+	slices := make([]reflect.Value, len(s.Shards))
+	errs := make([]error, len(s.Shards))
+	var wg sync.WaitGroup
+	for n, shard := range s.Shards {
+		wg.Add(1)
+		go func(n int, shard *DbMap) {
+			defer wg.Done()
+			holder := i
+			if slice.IsValid() {
+				slices[n] = reflect.New(slice.Type())
+				holder = slices[n].Interface()
+			}
+			lists[n], errs[n] = shard.Select(holder, query, args...)
+		}(n, shard)
+	}
+	wg.Wait()
+
+	var nonFatal error
+	for n, err := range errs {
+		switch {
+		case err == nil:
+		case !NonFatalError(err):
+			return nil, fmt.Errorf("gorp: shard %d: %w", n, err)
+		case nonFatal == nil:
+			nonFatal = err
+		}
+	}
+	if slice.IsValid() {
+		for _, rows := range slices {
+			slice.Set(reflect.AppendSlice(slice, rows.Elem()))
+		}
+		return nil, nonFatal
+	}
+	var list []interface{}
+	for _, rows := range lists {
+		list = append(list, rows...)
+	}
+	return list, nonFatal
+}
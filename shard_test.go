@@ -0,0 +1,196 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !integration
+// +build !integration
+
+package gorp_test
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/go-gorp/gorp/v3"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type TenantInvoice struct {
+	TenantId int64
+	Id       int64
+	Memo     string
+}
+
+// shardByTenant shards rows by their first key, the tenant.
+func shardByTenant(t *gorp.TableMap, row interface{}, keys []interface{}) (int, error) {
+	tenant, ok := keys[0].(int64)
+	if !ok {
+		return 0, fmt.Errorf("tenant %v is not an int64", keys[0])
+	}
+	return int(tenant % 3), nil
+}
+
+func newShardedDbMap(t *testing.T) *gorp.ShardedDbMap {
+	dir := t.TempDir()
+	var shards []*gorp.DbMap
+	for i := 0; i < 3; i++ {
+		db, err := sql.Open("sqlite3", filepath.Join(dir, fmt.Sprintf("shard%d.db", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		shards = append(shards, &gorp.DbMap{Db: db, Dialect: gorp.SqliteDialect{}})
+	}
+	sharded := gorp.NewShardedDbMap(shardByTenant, shards...)
+	sharded.AddTableWithName(TenantInvoice{}, "invoice").SetKeys(false, "TenantId", "Id")
+	if err := sharded.CreateTablesIfNotExists(); err != nil {
+		t.Fatal(err)
+	}
+	return sharded
+}
+
+func TestShardedDbMap(t *testing.T) {
+	sharded := newShardedDbMap(t)
+
+	var invoices []interface{}
+	for tenant := int64(0); tenant < 6; tenant++ {
+		invoices = append(invoices, &TenantInvoice{tenant, 1, fmt.Sprintf("t%d", tenant)})
+	}
+	if err := sharded.Insert(invoices...); err != nil {
+		t.Fatal(err)
+	}
+	for i, shard := range sharded.Shards {
+		var got []string
+		if _, err := shard.Select(&got, "select Memo from invoice order by Memo"); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{fmt.Sprintf("t%d", i), fmt.Sprintf("t%d", i+3)}
+		if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("Expected shard %d to hold %v, got %v", i, want, got)
+		}
+	}
+
+	obj, err := sharded.Get(TenantInvoice{}, int64(4), int64(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv, ok := obj.(*TenantInvoice); !ok || inv.Memo != "t4" {
+		t.Fatalf("Expected the invoice of tenant 4, got %v", obj)
+	}
+	inv := obj.(*TenantInvoice)
+	inv.Memo = "updated"
+	if n, err := sharded.Update(inv); err != nil || n != 1 {
+		t.Errorf("Expected 1 row updated, got %d, %v", n, err)
+	}
+	shard, err := sharded.ShardOf(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shard != sharded.Shards[1] {
+		t.Errorf("Expected tenant 4 to be on shard 1")
+	}
+	if memo, err := shard.SelectStr("select Memo from invoice where TenantId = 4"); err != nil || memo != "updated" {
+		t.Errorf("Expected the update to be written to shard 1, got %q, %v", memo, err)
+	}
+
+	if n, err := sharded.Delete(invoices[0], invoices[5]); err != nil || n != 2 {
+		t.Errorf("Expected 2 rows deleted, got %d, %v", n, err)
+	}
+	if obj, err := sharded.Get(TenantInvoice{}, int64(5), int64(1)); err != nil || obj != nil {
+		t.Errorf("Expected the deleted invoice to be gone, got %v, %v", obj, err)
+	}
+
+	var all []TenantInvoice
+	if _, err := sharded.Select(&all, "select * from invoice"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := sharded.Select(TenantInvoice{}, "select * from invoice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var memos []string
+	for _, inv := range all {
+		memos = append(memos, inv.Memo)
+	}
+	sort.Strings(memos)
+	if fmt.Sprint(memos) != "[t1 t2 t3 updated]" || len(list) != 4 {
+		t.Errorf("Expected the invoices of all shards, got %v and %d rows", memos, len(list))
+	}
+
+	// columns missing from the type don't keep the rows of the other shards
+	var extra []TenantInvoice
+	_, err = sharded.Select(&extra, "select *, 1 as Extra from invoice")
+	if !gorp.NonFatalError(err) || len(extra) != 4 {
+		t.Errorf("Expected the invoices of all shards and a non-fatal error, got %d rows and %v", len(extra), err)
+	}
+	list, err = sharded.Select(TenantInvoice{}, "select *, 1 as Extra from invoice")
+	if !gorp.NonFatalError(err) || len(list) != 4 {
+		t.Errorf("Expected the invoices of all shards and a non-fatal error, got %d rows and %v", len(list), err)
+	}
+
+	if _, err := sharded.Get(TenantInvoice{}, "tenant", int64(1)); err == nil {
+		t.Errorf("Expected the error of the shard key function")
+	}
+	sharded.ShardKey = func(*gorp.TableMap, interface{}, []interface{}) (int, error) { return 3, nil }
+	if err := sharded.Insert(&TenantInvoice{7, 1, "t7"}); err == nil {
+		t.Errorf("Expected an error for a shard out of range")
+	}
+}
+
+func TestShardedDbMapMismatchedShards(t *testing.T) {
+	expectPanic := func(name string, fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected %s to panic", name)
+			}
+		}()
+		fn()
+	}
+
+	expectPanic("shards with different dialects", func() {
+		gorp.NewShardedDbMap(shardByTenant,
+			&gorp.DbMap{Dialect: gorp.SqliteDialect{}}, &gorp.DbMap{Dialect: gorp.PostgresDialect{}})
+	})
+
+	shard0, shard1 := &gorp.DbMap{Dialect: gorp.SqliteDialect{}}, &gorp.DbMap{Dialect: gorp.SqliteDialect{}}
+	sharded := gorp.NewShardedDbMap(shardByTenant, shard0, shard1)
+	shard1.Now = func() time.Time { return time.Time{} }
+	expectPanic("sharing a table between shards with different clocks", func() {
+		sharded.AddTableWithName(TenantInvoice{}, "invoice")
+	})
+}
+
+func TestShardedDbMapIndexes(t *testing.T) {
+	sharded := newShardedDbMap(t)
+	table := sharded.AddTableWithName(TenantInvoice{}, "invoice")
+	table.AddIndex("invoice_memo_idx", "", []string{"Memo"})
+
+	countIndexes := func() []int64 {
+		var counts []int64
+		for _, shard := range sharded.Shards {
+			n, err := shard.SelectInt("select count(*) from sqlite_master where type = 'index' and name = 'invoice_memo_idx'")
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts = append(counts, n)
+		}
+		return counts
+	}
+	if err := sharded.CreateIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(countIndexes()); got != "[1 1 1]" {
+		t.Errorf("Expected the index on all shards, got %s", got)
+	}
+	if err := sharded.DropIndex(table, "invoice_memo_idx"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(countIndexes()); got != "[0 0 0]" {
+		t.Errorf("Expected the index to be dropped on all shards, got %s", got)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"database/sql/driver"
+	"reflect"
+)
+
+var snapshotType = reflect.TypeOf(Snapshot{})
+
+// Snapshot turns on dirty tracking for the struct embedding it.  Rows
+// loaded with Get, Select, SelectOne, SelectIter or the Query builder,
+// and rows written with Insert, Upsert or Update, remember the values of
+// their columns.  Update then writes only the columns whose value changed
+// since, together with the version and updated timestamp columns, and
+// skips the row entirely if none did: Update does not count it, but still
+// calls its PreUpdate and PostUpdate hooks.  Rows that have no snapshot
+// yet are updated in full.
+//
+// The embedded Snapshot is not mapped to a column.
+//
+// Example:
+//
+//	type Invoice struct {
+//		gorp.Snapshot
+//		Id   int64
+//		Memo string
+//	}
+type Snapshot struct {
+	// values holds the column values by field name.  It is replaced, not
+	// modified, so copies of a row don't share later snapshots.
+	values map[string]interface{}
+}
+
+func (s *Snapshot) gorpSnapshot() *Snapshot {
+	return s
+}
+
+type snapshotter interface {
+	gorpSnapshot() *Snapshot
+}
+
+// snapshotOf returns the Snapshot embedded in the struct elem, or nil.
+func snapshotOf(elem reflect.Value) *Snapshot {
+	if !elem.CanAddr() {
+		return nil
+	}
+	if s, ok := elem.Addr().Interface().(snapshotter); ok {
+		return s.gorpSnapshot()
+	}
+	return nil
+}
+
+// takeSnapshot records the column values of the struct elem, if it embeds
+// a Snapshot.
+func (t *TableMap) takeSnapshot(elem reflect.Value) {
+	s := snapshotOf(elem)
+	if s == nil {
+		return
+	}
+	values := make(map[string]interface{}, len(t.Columns))
+	for _, col := range t.Columns {
+		if !col.Transient {
+			values[col.fieldName] = snapshotValue(t.dbmap.TypeConverter, elem.FieldByName(col.fieldName))
+		}
+	}
+	s.values = values
+}
+
+// snapshotRows records the snapshots of the rows returned by a select
+// into i: the pointers of list, or the elements of the slice i points to.
+func snapshotRows(m *DbMap, i interface{}, list []interface{}) {
+	if t, _ := toSliceType(i); t != nil {
+		rows := reflect.Indirect(reflect.ValueOf(i))
+		list = make([]interface{}, 0, rows.Len())
+		for x := 0; x < rows.Len(); x++ {
+			row := rows.Index(x)
+			if row.Kind() != reflect.Ptr {
+				row = row.Addr()
+			}
+			list = append(list, row.Interface())
+		}
+	}
+	for _, row := range list {
+		v := reflect.ValueOf(row)
+		if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct || snapshotOf(v.Elem()) == nil {
+			continue
+		}
+		if table, elem, err := m.tableForPointer(row, false); err == nil {
+			table.takeSnapshot(elem)
+		}
+	}
+}
+
+// changedColumns returns the columns of elem accepted by colFilter whose
+// value differs from its snapshot, and false if elem has no snapshot.
+func (t *TableMap) changedColumns(elem reflect.Value, colFilter ColumnFilter) (map[*ColumnMap]bool, bool) {
+	s := snapshotOf(elem)
+	if s == nil || s.values == nil {
+		return nil, false
+	}
+	changed := make(map[*ColumnMap]bool)
+	for _, col := range t.Columns {
+		if col.Transient || col.isPK || col == t.version || colFilter != nil && !colFilter(col) {
+			continue
+		}
+		old, ok := s.values[col.fieldName]
+		if !ok || !reflect.DeepEqual(old, snapshotValue(t.dbmap.TypeConverter, elem.FieldByName(col.fieldName))) {
+			changed[col] = true
+		}
+	}
+	return changed, true
+}
+
+// snapshotValue returns the value f is written to the database as, in a
+// form that is not changed by later changes to f.
+func snapshotValue(conv TypeConverter, f reflect.Value) interface{} {
+	val := f.Interface()
+	if conv != nil {
+		if v, err := conv.ToDb(val); err == nil {
+			val = v
+		}
+	}
+	if valuer, ok := val.(driver.Valuer); ok {
+		if v, err := valuer.Value(); err == nil {
+			val = v
+		}
+	}
+	v := reflect.ValueOf(val)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice && !v.IsNil() {
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		return c.Interface()
+	}
+	if v.IsValid() && v.Kind() != reflect.Ptr {
+		return v.Interface()
+	}
+	return val
+}
@@ -0,0 +1,226 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SetSoftDeleteCol sets the column marking rows as deleted.  Once set,
+// Delete no longer removes rows but sets the column: to the current time
+// if the field is a *time.Time, sql.NullTime or NullTime, or to true if it
+// is a bool.  Get, the queries built with From and the relations loaded
+// with Preload then skip rows so marked.
+//
+// Use HardDelete to remove rows, Restore to clear the mark, and Unscoped
+// to read soft deleted rows.  Returns the column found, or panics if the
+// struct does not contain a field matching this name or the field is of
+// another type.
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+func (t *TableMap) SetSoftDeleteCol(field string) *ColumnMap {
+	c := t.ColMap(field)
+	if typ := t.fieldType(c); typ.Kind() != reflect.Bool && (typ == timeType || !isTimeType(typ)) {
+		panic(fmt.Sprintf("gorp: soft delete field %s of %s must be a bool or a nullable time, got %s",
+			field, t.gotype.Name(), typ))
+	}
+	t.softDelete = c
+	t.ResetSql()
+	return c
+}
+
+// softDeleteCond returns the condition matching the rows that are soft
+// deleted, or those that are not, with a "?" placeholder for its
+// argument if it has one.
+func (t *TableMap) softDeleteCond(deleted bool) (string, []interface{}) {
+	col := t.dbmap.Dialect.QuoteField(t.softDelete.ColumnName)
+	if t.fieldType(t.softDelete).Kind() == reflect.Bool {
+		return col + " = ?", []interface{}{deleted}
+	}
+	if deleted {
+		return col + " is not null", nil
+	}
+	return col + " is null", nil
+}
+
+// softDeleteValue returns the value of the soft delete field marking a
+// row as deleted, or as not deleted.
+func (t *TableMap) softDeleteValue(deleted bool) reflect.Value {
+	typ := t.fieldType(t.softDelete)
+	v := reflect.New(typ).Elem()
+	switch {
+	case !deleted:
+		return v
+	case typ.Kind() == reflect.Bool:
+		v.SetBool(true)
+		return v
+	}
+	return timeValue(typ, t.dbmap.now())
+}
+
+// filtered reports whether queries on t run by exec skip soft deleted
+// rows.
+func (t *TableMap) filtered(exec SqlExecutor) bool {
+	return t.softDelete != nil && !isUnscoped(exec)
+}
+
+// bindSoftDelete binds elem to the update statement marking its row as
+// deleted, or restoring it.  The update only matches rows in the opposite
+// state, and increments the version column of the row if t has one.  It
+// also returns the new value of the soft delete field, to be set on elem
+// once the statement succeeds.
+func (t *TableMap) bindSoftDelete(elem reflect.Value, deleted bool) (bindInstance, reflect.Value, error) {
+	plan := &t.softDeletePlan
+	if !deleted {
+		plan = &t.restorePlan
+	}
+	cond, condArgs := t.softDeleteCond(!deleted)
+	plan.once.Do(func() {
+		dialect := t.dbmap.Dialect
+		s := bytes.Buffer{}
+		s.WriteString(fmt.Sprintf("update %s set %s=%s",
+			dialect.QuotedTableForQuery(t.SchemaName, t.TableName),
+			dialect.QuoteField(t.softDelete.ColumnName), dialect.BindVar(0)))
+		if t.version != nil {
+			ver := dialect.QuoteField(t.version.ColumnName)
+			s.WriteString(fmt.Sprintf(", %s=%s+1", ver, ver))
+		}
+		s.WriteString(" where ")
+		x := 1
+		for _, col := range t.keys {
+			s.WriteString(dialect.QuoteField(col.ColumnName))
+			s.WriteString("=")
+			s.WriteString(dialect.BindVar(x))
+			s.WriteString(" and ")
+			plan.keyFields = append(plan.keyFields, col.fieldName)
+			plan.argFields = append(plan.argFields, col.fieldName)
+			x++
+		}
+		if t.version != nil {
+			plan.versField = t.version.fieldName
+			s.WriteString(dialect.QuoteField(t.version.ColumnName))
+			s.WriteString("=")
+			s.WriteString(dialect.BindVar(x))
+			s.WriteString(" and ")
+			plan.argFields = append(plan.argFields, plan.versField)
+			x++
+		}
+		s.WriteString(strings.Replace(cond, "?", dialect.BindVar(x), 1))
+		t.writeTenantCond(&s, x+strings.Count(cond, "?"))
+		s.WriteString(dialect.QuerySuffix())
+		plan.query = s.String()
+	})
+
+	bi, err := plan.createBindInstance(elem, t)
+	if err != nil {
+		return bindInstance{}, reflect.Value{}, err
+	}
+	value := t.softDeleteValue(deleted)
+	arg := value.Interface()
+	if conv := t.dbmap.TypeConverter; conv != nil {
+		if arg, err = conv.ToDb(arg); err != nil {
+			return bindInstance{}, reflect.Value{}, err
+		}
+	}
+	bi.args = append(append([]interface{}{arg}, bi.args...), condArgs...)
+	return bi, value, nil
+}
+
+// Unscoped returns a copy of this DbMap that does not skip soft deleted
+// rows; see TableMap.SetSoftDeleteCol.  Transactions begun from it don't
+// skip them either.
+func (m *DbMap) Unscoped() *DbMap {
+	copy := &DbMap{}
+	*copy = *m
+	copy.unscoped = true
+	return copy
+}
+
+// Unscoped returns a copy of this Transaction that does not skip soft
+// deleted rows; see TableMap.SetSoftDeleteCol.
+func (t *Transaction) Unscoped() *Transaction {
+	copy := &Transaction{}
+	*copy = *t
+	copy.unscoped = true
+	return copy
+}
+
+func isUnscoped(exec SqlExecutor) bool {
+	switch e := exec.(type) {
+	case *DbMap:
+		return e.unscoped
+	case *Transaction:
+		return e.unscoped || e.dbmap.unscoped
+	}
+	return false
+}
+
+// HardDelete deletes the rows of list, as Delete does for tables without a
+// soft delete column, whether or not they have one.
+func (m *DbMap) HardDelete(list ...interface{}) (int64, error) {
+	return deleteRows(m, m, true, list...)
+}
+
+// HardDelete has the same behavior as DbMap.HardDelete(), but runs in a transaction.
+func (t *Transaction) HardDelete(list ...interface{}) (int64, error) {
+	return deleteRows(t.dbmap, t, true, list...)
+}
+
+// Restore clears the soft delete column of the rows of list, which must
+// belong to tables with one, and of the structs themselves, incrementing
+// their version as Update does.  Returns the number of rows restored;
+// rows that were not soft deleted, or whose version does not match, are
+// not counted.
+func (m *DbMap) Restore(list ...interface{}) (int64, error) {
+	return restore(m, m, list...)
+}
+
+// Restore has the same behavior as DbMap.Restore(), but runs in a transaction.
+func (t *Transaction) Restore(list ...interface{}) (int64, error) {
+	return restore(t.dbmap, t, list...)
+}
+
+func restore(m *DbMap, exec SqlExecutor, list ...interface{}) (int64, error) {
+	count := int64(0)
+	for _, ptr := range list {
+		table, elem, err := m.tableForPointer(ptr, true)
+		if err != nil {
+			return -1, err
+		}
+		if table.softDelete == nil {
+			return -1, fmt.Errorf("gorp: table %s has no soft delete column", table.TableName)
+		}
+
+		bi, value, err := table.bindSoftDelete(elem, false)
+		if err != nil {
+			return -1, err
+		}
+		tenantArgs, err := table.tenantArgs(exec)
+		if err != nil {
+			return -1, err
+		}
+		bi.args = append(bi.args, tenantArgs...)
+		res, err := withCall(exec, table, "Restore").Exec(bi.query, bi.args...)
+		if err != nil {
+			return -1, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return -1, err
+		}
+		if rows > 0 {
+			elem.FieldByName(table.softDelete.fieldName).Set(value)
+			if bi.versField != "" {
+				elem.FieldByName(bi.versField).SetInt(bi.existingVersion + 1)
+			}
+		}
+		m.uncache(exec, table, elem)
+		count += rows
+	}
+	return count, nil
+}
@@ -0,0 +1,264 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram of each
+// statement: 100µs doubling up to about 52s.
+var latencyBuckets = func() []time.Duration {
+	b := make([]time.Duration, 20)
+	for i := range b {
+		b[i] = 100 * time.Microsecond << uint(i)
+	}
+	return b
+}()
+
+// Stats aggregates statistics on the statements run by a DbMap and its
+// Transactions, by normalized statement.  See DbMap.StatsOn.
+type Stats struct {
+	slow   time.Duration
+	onSlow func(ev *QueryEvent)
+
+	mu         sync.Mutex
+	statements map[string]*statementStats
+}
+
+type statementStats struct {
+	count   int64
+	errors  int64
+	rows    int64
+	total   time.Duration
+	max     time.Duration
+	buckets []int64 // counts by latencyBuckets, plus one for longer calls
+}
+
+// StatementStats holds the statistics of a normalized statement.
+type StatementStats struct {
+	// Query is the statement with its literals and bind variables
+	// replaced by "?", and lists of them collapsed, so that statements
+	// differing only in their arguments share statistics.
+	Query string
+
+	Count        int64
+	Errors       int64
+	RowsAffected int64
+
+	Total time.Duration
+	Avg   time.Duration
+	Max   time.Duration
+
+	// P99 is the 99th percentile latency, estimated from a histogram: it
+	// is the upper bound of the bucket holding it, up to Max.
+	P99 time.Duration
+}
+
+// StatsOn turns on collecting statistics on the statements run by this
+// DbMap, available from Stats.  They are collected for every statement
+// passing through the interceptors of the DbMap, including begin, commit
+// and rollback.
+//
+// If slow is not zero, onSlow is called with the event of each statement
+// taking at least that long, once it completes.  Its Args hold the
+// sensitive values masked; see ColumnMap.SetSensitive.  onSlow may be nil
+// to collect statistics only.
+func (m *DbMap) StatsOn(slow time.Duration, onSlow func(ev *QueryEvent)) {
+	m.stats = &Stats{
+		slow:       slow,
+		onSlow:     onSlow,
+		statements: make(map[string]*statementStats),
+	}
+}
+
+// StatsOff turns off collecting statistics and discards them.  It is
+// idempotent.
+func (m *DbMap) StatsOff() {
+	m.stats = nil
+}
+
+// Stats returns the statistics collected since StatsOn was called, or
+// nil if it was not.
+func (m *DbMap) Stats() *Stats {
+	return m.stats
+}
+
+// record adds the call described by ev, which returned err, to s.
+func (s *Stats) record(ev *QueryEvent, err error) {
+	key := NormalizeQuery(ev.Query)
+
+	s.mu.Lock()
+	st, ok := s.statements[key]
+	if !ok {
+		st = &statementStats{buckets: make([]int64, len(latencyBuckets)+1)}
+		s.statements[key] = st
+	}
+	st.count++
+	if err != nil {
+		st.errors++
+	}
+	if ev.RowsAffected > 0 {
+		st.rows += ev.RowsAffected
+	}
+	st.total += ev.Duration
+	if ev.Duration > st.max {
+		st.max = ev.Duration
+	}
+	st.buckets[sort.Search(len(latencyBuckets), func(i int) bool {
+		return ev.Duration <= latencyBuckets[i]
+	})]++
+	s.mu.Unlock()
+
+	if s.onSlow != nil && s.slow > 0 && ev.Duration >= s.slow {
+		slow := *ev
+		slow.Args = RedactArgs(ev.Args)
+		s.onSlow(&slow)
+	}
+}
+
+// Snapshot returns the statistics of each statement, by decreasing total
+// latency.
+func (s *Stats) Snapshot() []StatementStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := make([]StatementStats, 0, len(s.statements))
+	for query, st := range s.statements {
+		snapshot = append(snapshot, StatementStats{
+			Query:        query,
+			Count:        st.count,
+			Errors:       st.errors,
+			RowsAffected: st.rows,
+			Total:        st.total,
+			Avg:          st.total / time.Duration(st.count),
+			Max:          st.max,
+			P99:          st.percentile(0.99),
+		})
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Total != snapshot[j].Total {
+			return snapshot[i].Total > snapshot[j].Total
+		}
+		return snapshot[i].Query < snapshot[j].Query
+	})
+	return snapshot
+}
+
+// percentile returns the upper bound of the latency bucket holding the
+// percentile p, up to the maximum latency.
+func (st *statementStats) percentile(p float64) time.Duration {
+	rank := int64(math.Ceil(p * float64(st.count)))
+	var n int64
+	for i, c := range st.buckets {
+		n += c
+		if n >= rank {
+			if i < len(latencyBuckets) && latencyBuckets[i] < st.max {
+				return latencyBuckets[i]
+			}
+			break
+		}
+	}
+	return st.max
+}
+
+// Reset discards the statistics collected so far.
+func (s *Stats) Reset() {
+	s.mu.Lock()
+	s.statements = make(map[string]*statementStats)
+	s.mu.Unlock()
+}
+
+// WritePrometheus writes the statistics in the Prometheus text exposition
+// format, labelled by normalized statement: the counters
+// gorp_queries_total, gorp_query_errors_total and
+// gorp_query_rows_affected_total, and the histogram
+// gorp_query_duration_seconds.
+func (s *Stats) WritePrometheus(w io.Writer) error {
+	type entry struct {
+		label string
+		st    statementStats
+	}
+	s.mu.Lock()
+	entries := make([]entry, 0, len(s.statements))
+	for query, st := range s.statements {
+		c := *st
+		c.buckets = append([]int64(nil), st.buckets...)
+		entries = append(entries, entry{`query="` + escapeLabel(query) + `"`, c})
+	}
+	s.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].label < entries[j].label })
+
+	b := &strings.Builder{}
+	counter := func(name, help string, value func(st statementStats) int64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, e := range entries {
+			fmt.Fprintf(b, "%s{%s} %d\n", name, e.label, value(e.st))
+		}
+	}
+	counter("gorp_queries_total", "Number of statements run.",
+		func(st statementStats) int64 { return st.count })
+	counter("gorp_query_errors_total", "Number of statements that failed.",
+		func(st statementStats) int64 { return st.errors })
+	counter("gorp_query_rows_affected_total", "Number of rows affected by statements.",
+		func(st statementStats) int64 { return st.rows })
+
+	const name = "gorp_query_duration_seconds"
+	fmt.Fprintf(b, "# HELP %s Latency of statements.\n# TYPE %s histogram\n", name, name)
+	for _, e := range entries {
+		var n int64
+		for i, bound := range latencyBuckets {
+			n += e.st.buckets[i]
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", name, e.label,
+				strconv.FormatFloat(bound.Seconds(), 'g', -1, 64), n)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, e.label, e.st.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, e.label,
+			strconv.FormatFloat(e.st.total.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, e.label, e.st.count)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+var (
+	stringLiteralRegexp = regexp.MustCompile(`'(?:[^']|'')*'`)
+	bindVarRegexp       = regexp.MustCompile(`\$\d+|:\d+|@p\d+|\?`)
+	numberRegexp        = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	spaceRegexp         = regexp.MustCompile(`\s+`)
+	valueListRegexp     = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	rowListRegexp       = regexp.MustCompile(`\(\?\)(?:\s*,\s*\(\?\))+`)
+)
+
+// NormalizeQuery returns the statement that the statistics of query are
+// aggregated under: query with its string and number literals and bind
+// variables replaced by "?", lists of them collapsed to "(?)", and its
+// whitespace collapsed.
+//
+// Example:
+//
+//	NormalizeQuery("select * from t where id in ($1, $2) and name = 'x'")
+//	// select * from t where id in (?) and name = ?
+func NormalizeQuery(query string) string {
+	q := stringLiteralRegexp.ReplaceAllString(query, "?")
+	q = bindVarRegexp.ReplaceAllString(q, "?")
+	q = numberRegexp.ReplaceAllString(q, "?")
+	q = spaceRegexp.ReplaceAllString(q, " ")
+	q = valueListRegexp.ReplaceAllString(q, "(?)")
+	q = rowListRegexp.ReplaceAllString(q, "(?)")
+	return strings.TrimSuffix(strings.TrimSpace(q), ";")
+}
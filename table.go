@@ -22,11 +22,18 @@ type TableMap struct {
 	keys           []*ColumnMap
 	indexes        []*IndexMap
 	uniqueTogether [][]string
+	uniqueNames    []string
 	version        *ColumnMap
+	softDelete     *ColumnMap
+	tenant         *ColumnMap
+	relations      []Relation
 	insertPlan     bindPlan
 	updatePlan     bindPlan
 	deletePlan     bindPlan
 	getPlan        bindPlan
+	getLivePlan    bindPlan
+	softDeletePlan bindPlan
+	restorePlan    bindPlan
 	dbmap          *DbMap
 }
 
@@ -38,6 +45,9 @@ func (t *TableMap) ResetSql() {
 	t.updatePlan = bindPlan{}
 	t.deletePlan = bindPlan{}
 	t.getPlan = bindPlan{}
+	t.getLivePlan = bindPlan{}
+	t.softDeletePlan = bindPlan{}
+	t.restorePlan = bindPlan{}
 }
 
 // SetKeys lets you specify the fields on a struct that map to primary
@@ -75,6 +85,20 @@ func (t *TableMap) SetKeys(isAutoIncr bool, fieldNames ...string) *TableMap {
 // Panics if fieldNames length < 2.
 //
 func (t *TableMap) SetUniqueTogether(fieldNames ...string) *TableMap {
+	return t.SetUniqueTogetherWithName("", fieldNames...)
+}
+
+// SetUniqueTogetherWithName has the same behavior as SetUniqueTogether, but
+// names the constraint.  The name is used for the constraint in create
+// table statements, and identifies the columns as a conflict target for
+// UpsertColumns.
+//
+// Naming columns already registered with SetUniqueTogether names the
+// existing constraint rather than adding another one.
+//
+// Panics if fieldNames length < 2.
+//
+func (t *TableMap) SetUniqueTogetherWithName(name string, fieldNames ...string) *TableMap {
 	if len(fieldNames) < 2 {
 		panic(fmt.Sprintf(
 			"gorp: SetUniqueTogether: must provide at least two fieldNames to set uniqueness constraint."))
@@ -85,17 +109,59 @@ func (t *TableMap) SetUniqueTogether(fieldNames ...string) *TableMap {
 		columns = append(columns, name)
 	}
 
-	for _, existingColumns := range t.uniqueTogether {
+	for i, existingColumns := range t.uniqueTogether {
 		if equal(existingColumns, columns) {
+			if name != "" {
+				t.uniqueNames[i] = name
+			}
 			return t
 		}
 	}
 	t.uniqueTogether = append(t.uniqueTogether, columns)
+	t.uniqueNames = append(t.uniqueNames, name)
 	t.ResetSql()
 
 	return t
 }
 
+// hasNewAutoIncrKey reports whether the columns of the unique constraint
+// named conflict (see uniqueColumns) include an auto-increment column that
+// is zero in elem: the row is new, and its key is to be generated.
+func (t *TableMap) hasNewAutoIncrKey(elem reflect.Value, conflict string) bool {
+	target, err := t.uniqueColumns(conflict)
+	if err != nil {
+		return false
+	}
+	for _, col := range target {
+		if col.isAutoIncr && elem.FieldByName(col.fieldName).IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueColumns returns the columns of the unique constraint registered
+// with SetUniqueTogetherWithName under name, or the primary key columns
+// if name is empty.
+func (t *TableMap) uniqueColumns(name string) ([]*ColumnMap, error) {
+	if name == "" {
+		if len(t.keys) < 1 {
+			return nil, fmt.Errorf("gorp: no keys defined for table: %s", t.TableName)
+		}
+		return t.keys, nil
+	}
+	for i, uniqueName := range t.uniqueNames {
+		if uniqueName == name {
+			cols := make([]*ColumnMap, 0, len(t.uniqueTogether[i]))
+			for _, field := range t.uniqueTogether[i] {
+				cols = append(cols, t.ColMap(field))
+			}
+			return cols, nil
+		}
+	}
+	return nil, fmt.Errorf("gorp: no unique constraint named %s for table: %s", name, t.TableName)
+}
+
 // ColMap returns the ColumnMap pointer matching the given struct field
 // name.  It panics if the struct does not contain a field matching this
 // name.
@@ -228,8 +294,12 @@ func (t *TableMap) SqlForCreate(ifNotExists bool) string {
 		s.WriteString(")")
 	}
 	if len(t.uniqueTogether) > 0 {
-		for _, columns := range t.uniqueTogether {
-			s.WriteString(", unique (")
+		for i, columns := range t.uniqueTogether {
+			s.WriteString(", ")
+			if t.uniqueNames[i] != "" {
+				s.WriteString(fmt.Sprintf("constraint %s ", dialect.QuoteField(t.uniqueNames[i])))
+			}
+			s.WriteString("unique (")
 			for i, column := range columns {
 				if i > 0 {
 					s.WriteString(", ")
@@ -239,6 +309,17 @@ func (t *TableMap) SqlForCreate(ifNotExists bool) string {
 			s.WriteString(")")
 		}
 	}
+	for _, col := range t.Columns {
+		if col.Transient || col.ForeignKey == nil {
+			continue
+		}
+		fk := col.ForeignKey
+		schema, table := fk.schemaAndTable()
+		s.WriteString(", ")
+		s.WriteString(foreignKeySql(dialect, dialect.QuoteField(col.ColumnName),
+			dialect.QuotedTableForQuery(schema, table), dialect.QuoteField(fk.Column),
+			fk.OnDelete, fk.OnUpdate))
+	}
 	s.WriteString(") ")
 	s.WriteString(dialect.CreateTableSuffix())
 	s.WriteString(dialect.QuerySuffix())
@@ -256,3 +337,19 @@ func equal(a, b []string) bool {
 	}
 	return true
 }
+
+// references returns true if a column of t has a foreign key to table
+// other.
+func (t *TableMap) references(other *TableMap) bool {
+	for _, col := range t.Columns {
+		if col.Transient || col.ForeignKey == nil {
+			continue
+		}
+		schema, table := col.ForeignKey.schemaAndTable()
+		if strings.EqualFold(table, other.TableName) &&
+			(schema == "" || strings.EqualFold(schema, other.SchemaName)) {
+			return true
+		}
+	}
+	return false
+}
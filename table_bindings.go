@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
 )
 
@@ -46,9 +47,45 @@ type bindPlan struct {
 	autoIncrIdx       int
 	autoIncrFieldName string
 	once              sync.Once
+
+	// Only used by insert plans: the statement up to and including
+	// "values", and the value expression of each column, where an empty
+	// string stands for a bind variable.
+	insertHead   string
+	insertValues []string
+
+	// sensitive marks the argFields of sensitive columns, or is nil if
+	// there are none; see sensitiveArgs.
+	sensitive     []bool
+	sensitiveOnce sync.Once
 }
 
-func (plan *bindPlan) createBindInstance(elem reflect.Value, conv TypeConverter) (bindInstance, error) {
+// sensitiveArgs returns which argFields of plan belong to sensitive
+// columns of t, or nil if none do.  It is computed once per plan.
+func (plan *bindPlan) sensitiveArgs(t *TableMap) []bool {
+	plan.sensitiveOnce.Do(func() {
+		var fields map[string]bool
+		for _, col := range t.Columns {
+			if col.sensitive {
+				if fields == nil {
+					fields = make(map[string]bool)
+				}
+				fields[col.fieldName] = true
+			}
+		}
+		if fields == nil {
+			return
+		}
+		plan.sensitive = make([]bool, len(plan.argFields))
+		for i, field := range plan.argFields {
+			plan.sensitive[i] = fields[field]
+		}
+	})
+	return plan.sensitive
+}
+
+func (plan *bindPlan) createBindInstance(elem reflect.Value, t *TableMap) (bindInstance, error) {
+	conv := t.dbmap.TypeConverter
 	bi := bindInstance{query: plan.query, autoIncrIdx: plan.autoIncrIdx, autoIncrFieldName: plan.autoIncrFieldName, versField: plan.versField}
 	if plan.versField != "" {
 		bi.existingVersion = elem.FieldByName(plan.versField).Int()
@@ -56,6 +93,7 @@ func (plan *bindPlan) createBindInstance(elem reflect.Value, conv TypeConverter)
 
 	var err error
 
+	sensitive := plan.sensitiveArgs(t)
 	for i := 0; i < len(plan.argFields); i++ {
 		k := plan.argFields[i]
 		if k == versFieldConst {
@@ -72,6 +110,9 @@ func (plan *bindPlan) createBindInstance(elem reflect.Value, conv TypeConverter)
 					return bindInstance{}, err
 				}
 			}
+			if sensitive != nil && sensitive[i] {
+				val = Sensitive(val)
+			}
 			bi.args = append(bi.args, val)
 		}
 	}
@@ -102,15 +143,18 @@ type bindInstance struct {
 }
 
 func (t *TableMap) bindInsert(elem reflect.Value) (bindInstance, error) {
+	t.setTimestamps(elem, true)
+	return t.bindInsertPlan().createBindInstance(elem, t)
+}
+
+func (t *TableMap) bindInsertPlan() *bindPlan {
 	plan := &t.insertPlan
 	plan.once.Do(func() {
 		plan.autoIncrIdx = -1
 
 		s := bytes.Buffer{}
-		s2 := bytes.Buffer{}
 		s.WriteString(fmt.Sprintf("insert into %s (", t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
 
-		x := 0
 		first := true
 		for y := range t.Columns {
 			col := t.Columns[y]
@@ -118,26 +162,24 @@ func (t *TableMap) bindInsert(elem reflect.Value) (bindInstance, error) {
 				if !col.Transient {
 					if !first {
 						s.WriteString(",")
-						s2.WriteString(",")
 					}
 					s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
 
 					if col.isAutoIncr {
-						s2.WriteString(t.dbmap.Dialect.AutoIncrBindValue())
+						plan.insertValues = append(plan.insertValues, t.dbmap.Dialect.AutoIncrBindValue())
 						plan.autoIncrIdx = y
 						plan.autoIncrFieldName = col.fieldName
 					} else {
 						if col.DefaultValue == "" {
-							s2.WriteString(t.dbmap.Dialect.BindVar(x))
+							plan.insertValues = append(plan.insertValues, "")
 							if col == t.version {
 								plan.versField = col.fieldName
 								plan.argFields = append(plan.argFields, versFieldConst)
 							} else {
 								plan.argFields = append(plan.argFields, col.fieldName)
 							}
-							x++
 						} else {
-							s2.WriteString(col.DefaultValue)
+							plan.insertValues = append(plan.insertValues, col.DefaultValue)
 						}
 					}
 					first = false
@@ -147,9 +189,10 @@ func (t *TableMap) bindInsert(elem reflect.Value) (bindInstance, error) {
 				plan.autoIncrFieldName = col.fieldName
 			}
 		}
-		s.WriteString(") values (")
-		s.WriteString(s2.String())
-		s.WriteString(")")
+		s.WriteString(") values ")
+		plan.insertHead = s.String()
+
+		t.writeInsertRow(&s, plan, 0)
 		if plan.autoIncrIdx > -1 {
 			s.WriteString(t.dbmap.Dialect.AutoIncrInsertSuffix(t.Columns[plan.autoIncrIdx]))
 		}
@@ -158,67 +201,137 @@ func (t *TableMap) bindInsert(elem reflect.Value) (bindInstance, error) {
 		plan.query = s.String()
 	})
 
-	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+	return plan
+}
+
+// writeInsertRow writes the parenthesized value list for one row of an
+// insert statement, numbering bind variables from x.  It returns the index
+// of the next bind variable.
+func (t *TableMap) writeInsertRow(s *bytes.Buffer, plan *bindPlan, x int) int {
+	s.WriteString("(")
+	for i, val := range plan.insertValues {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		if val == "" {
+			s.WriteString(t.dbmap.Dialect.BindVar(x))
+			x++
+		} else {
+			s.WriteString(val)
+		}
+	}
+	s.WriteString(")")
+	return x
+}
+
+// bindInsertBatch binds every element in elems to a single multi-row insert
+// statement.  The returned bindInstance holds the args for all rows, in
+// row order.
+func (t *TableMap) bindInsertBatch(elems []reflect.Value) (bindInstance, error) {
+	plan := t.bindInsertPlan()
+
+	s := bytes.Buffer{}
+	s.WriteString(plan.insertHead)
+	x := 0
+	for i := range elems {
+		if i > 0 {
+			s.WriteString(",")
+		}
+		x = t.writeInsertRow(&s, plan, x)
+	}
+	if plan.autoIncrIdx > -1 {
+		s.WriteString(t.dbmap.Dialect.AutoIncrInsertSuffix(t.Columns[plan.autoIncrIdx]))
+	}
+	s.WriteString(t.dbmap.Dialect.QuerySuffix())
+
+	batch := bindInstance{
+		query:             s.String(),
+		autoIncrIdx:       plan.autoIncrIdx,
+		autoIncrFieldName: plan.autoIncrFieldName,
+		versField:         plan.versField,
+	}
+	for _, elem := range elems {
+		t.setTimestamps(elem, true)
+		bi, err := plan.createBindInstance(elem, t)
+		if err != nil {
+			return bindInstance{}, err
+		}
+		batch.args = append(batch.args, bi.args...)
+	}
+	return batch, nil
 }
 
 func (t *TableMap) bindUpdate(elem reflect.Value, colFilter ColumnFilter) (bindInstance, error) {
+	t.setTimestamps(elem, false)
+
+	plan := &t.updatePlan
 	if colFilter == nil {
-		colFilter = acceptAllFilter
+		plan.once.Do(func() {
+			t.buildUpdatePlan(plan, acceptAllFilter)
+		})
+	} else {
+		// the columns differ from filter to filter, so the plan is not
+		// cached
+		plan = &bindPlan{}
+		t.buildUpdatePlan(plan, colFilter)
 	}
 
-	plan := &t.updatePlan
-	plan.once.Do(func() {
-		s := bytes.Buffer{}
-		s.WriteString(fmt.Sprintf("update %s set ", t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
-		x := 0
+	return plan.createBindInstance(elem, t)
+}
 
-		for y := range t.Columns {
-			col := t.Columns[y]
-			if !col.isAutoIncr && !col.Transient && colFilter(col) {
-				if x > 0 {
-					s.WriteString(", ")
-				}
-				s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
-				s.WriteString("=")
-				s.WriteString(t.dbmap.Dialect.BindVar(x))
+// buildUpdatePlan builds the update statement of plan, setting the columns
+// accepted by colFilter.  The version column, if any, is always set.
+func (t *TableMap) buildUpdatePlan(plan *bindPlan, colFilter ColumnFilter) {
+	s := bytes.Buffer{}
+	s.WriteString(fmt.Sprintf("update %s set ", t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName)))
+	x := 0
 
-				if col == t.version {
-					plan.versField = col.fieldName
-					plan.argFields = append(plan.argFields, versFieldConst)
-				} else {
-					plan.argFields = append(plan.argFields, col.fieldName)
-				}
-				x++
-			}
-		}
-
-		s.WriteString(" where ")
-		for y := range t.keys {
-			col := t.keys[y]
-			if y > 0 {
-				s.WriteString(" and ")
+	for y := range t.Columns {
+		col := t.Columns[y]
+		if !col.isAutoIncr && !col.Transient && col != t.tenant && (col == t.version || colFilter(col)) {
+			if x > 0 {
+				s.WriteString(", ")
 			}
 			s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
 			s.WriteString("=")
 			s.WriteString(t.dbmap.Dialect.BindVar(x))
 
-			plan.argFields = append(plan.argFields, col.fieldName)
-			plan.keyFields = append(plan.keyFields, col.fieldName)
+			if col == t.version {
+				plan.versField = col.fieldName
+				plan.argFields = append(plan.argFields, versFieldConst)
+			} else {
+				plan.argFields = append(plan.argFields, col.fieldName)
+			}
 			x++
 		}
-		if plan.versField != "" {
+	}
+
+	s.WriteString(" where ")
+	for y := range t.keys {
+		col := t.keys[y]
+		if y > 0 {
 			s.WriteString(" and ")
-			s.WriteString(t.dbmap.Dialect.QuoteField(t.version.ColumnName))
-			s.WriteString("=")
-			s.WriteString(t.dbmap.Dialect.BindVar(x))
-			plan.argFields = append(plan.argFields, plan.versField)
 		}
-		s.WriteString(t.dbmap.Dialect.QuerySuffix())
+		s.WriteString(t.dbmap.Dialect.QuoteField(col.ColumnName))
+		s.WriteString("=")
+		s.WriteString(t.dbmap.Dialect.BindVar(x))
 
-		plan.query = s.String()
-	})
+		plan.argFields = append(plan.argFields, col.fieldName)
+		plan.keyFields = append(plan.keyFields, col.fieldName)
+		x++
+	}
+	if plan.versField != "" {
+		s.WriteString(" and ")
+		s.WriteString(t.dbmap.Dialect.QuoteField(t.version.ColumnName))
+		s.WriteString("=")
+		s.WriteString(t.dbmap.Dialect.BindVar(x))
+		plan.argFields = append(plan.argFields, plan.versField)
+		x++
+	}
+	t.writeTenantCond(&s, x)
+	s.WriteString(t.dbmap.Dialect.QuerySuffix())
 
-	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+	plan.query = s.String()
 }
 
 func (t *TableMap) bindDelete(elem reflect.Value) (bindInstance, error) {
@@ -257,16 +370,23 @@ func (t *TableMap) bindDelete(elem reflect.Value) (bindInstance, error) {
 
 			plan.argFields = append(plan.argFields, plan.versField)
 		}
+		t.writeTenantCond(&s, len(plan.argFields))
 		s.WriteString(t.dbmap.Dialect.QuerySuffix())
 
 		plan.query = s.String()
 	})
 
-	return plan.createBindInstance(elem, t.dbmap.TypeConverter)
+	return plan.createBindInstance(elem, t)
 }
 
-func (t *TableMap) bindGet() *bindPlan {
+// bindGet returns the plan selecting a row by its keys.  If live is true,
+// the query also skips soft deleted rows, and takes the arguments of the
+// soft delete condition after the keys.
+func (t *TableMap) bindGet(live bool) *bindPlan {
 	plan := &t.getPlan
+	if live {
+		plan = &t.getLivePlan
+	}
 	plan.once.Do(func() {
 		s := bytes.Buffer{}
 		s.WriteString("select ")
@@ -296,6 +416,14 @@ func (t *TableMap) bindGet() *bindPlan {
 
 			plan.keyFields = append(plan.keyFields, col.fieldName)
 		}
+		x = len(t.keys)
+		if live {
+			cond, _ := t.softDeleteCond(false)
+			s.WriteString(" and ")
+			s.WriteString(strings.Replace(cond, "?", t.dbmap.Dialect.BindVar(x), 1))
+			x += strings.Count(cond, "?")
+		}
+		t.writeTenantCond(&s, x)
 		s.WriteString(t.dbmap.Dialect.QuerySuffix())
 
 		plan.query = s.String()
@@ -303,3 +431,70 @@ func (t *TableMap) bindGet() *bindPlan {
 
 	return plan
 }
+
+// bindUpsert binds elem to an upsert statement built by upserter.  The
+// statement conflicts on the unique constraint named conflict, or on the
+// primary key if conflict is empty, and overwrites the columns accepted by
+// colFilter.  Key columns, conflict columns, columns with a default
+// value and created timestamp columns are never overwritten.
+func (t *TableMap) bindUpsert(elem reflect.Value, upserter Upserter, conflict string, colFilter ColumnFilter) (bindInstance, error) {
+	if colFilter == nil {
+		colFilter = acceptAllFilter
+	}
+
+	target, err := t.uniqueColumns(conflict)
+	if err != nil {
+		return bindInstance{}, err
+	}
+	inTarget := func(col *ColumnMap) bool {
+		for _, c := range target {
+			if c == col {
+				return true
+			}
+		}
+		return false
+	}
+
+	plan := &bindPlan{}
+	var cols, vals, conflictCols, update []string
+	x := 0
+	for _, col := range t.Columns {
+		if col.Transient || (col.isAutoIncr && !inTarget(col)) {
+			continue
+		}
+		cols = append(cols, col.ColumnName)
+		if col.DefaultValue != "" {
+			vals = append(vals, col.DefaultValue)
+			continue
+		}
+		vals = append(vals, t.dbmap.Dialect.BindVar(x))
+		x++
+
+		if col == t.version {
+			plan.versField = col.fieldName
+			plan.argFields = append(plan.argFields, versFieldConst)
+		} else {
+			plan.argFields = append(plan.argFields, col.fieldName)
+			if !col.isPK && !col.isCreateTime && !inTarget(col) && colFilter(col) {
+				update = append(update, col.ColumnName)
+			}
+		}
+	}
+	for _, col := range target {
+		conflictCols = append(conflictCols, col.ColumnName)
+	}
+	for _, col := range t.keys {
+		plan.keyFields = append(plan.keyFields, col.fieldName)
+	}
+
+	version := ""
+	if plan.versField != "" {
+		version = t.version.ColumnName
+		update = append(update, version)
+	}
+	plan.query = upserter.UpsertSql(t.dbmap.Dialect.QuotedTableForQuery(t.SchemaName, t.TableName),
+		cols, vals, conflictCols, update, version)
+
+	t.setTimestamps(elem, true)
+	return plan.createBindInstance(elem, t)
+}
@@ -0,0 +1,161 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrNoTenant is returned when a row of a table scoped by tenant is
+// written or read with a context holding no tenant; see
+// TableMap.SetTenantCol.
+var ErrNoTenant = errors.New("gorp: no tenant in context")
+
+// tenantKey is the context key of WithTenant.
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx holding the tenant that the rows of
+// tables scoped by tenant are read and written for; see
+// TableMap.SetTenantCol.
+//
+// Example:
+//
+//	exec := dbmap.WithContext(gorp.WithTenant(ctx, tenantId))
+//	err := exec.Insert(inv) // sets inv.TenantId
+//	obj, err := exec.Get(Invoice{}, inv.Id)
+func WithTenant(ctx context.Context, tenant interface{}) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant held by ctx, if any.
+func TenantFrom(ctx context.Context) (interface{}, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tenant := ctx.Value(tenantKey{})
+	return tenant, tenant != nil
+}
+
+// SetTenantCol sets the column holding the tenant of each row, scoping
+// the table by tenant.  Once set, Insert and InsertBatch set the column
+// to the tenant held by the context of the DbMap or Transaction (see
+// WithTenant), and Get, Update, Delete, Restore, the queries built with
+// From and the relations loaded with Preload only match the rows of that
+// tenant.  They return ErrNoTenant if the context holds none.  Update
+// never changes the column, Upsert is not supported, and Get does not use
+// the Cache for the table.
+//
+// Queries run with Select and the like are not scoped: they must filter
+// on the column themselves.  Returns the column found, or panics if the
+// struct does not contain a field matching this name.
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+func (t *TableMap) SetTenantCol(field string) *ColumnMap {
+	c := t.ColMap(field)
+	t.tenant = c
+	t.ResetSql()
+	return c
+}
+
+// tenantArgs returns the arguments of the tenant condition of the
+// statements of t run by exec: none if t is not scoped by tenant, or the
+// tenant held by the context of exec.
+func (t *TableMap) tenantArgs(exec SqlExecutor) ([]interface{}, error) {
+	if t.tenant == nil {
+		return nil, nil
+	}
+	_, ctx := extractExecutorAndContext(exec)
+	tenant, ok := TenantFrom(ctx)
+	if !ok {
+		return nil, fmt.Errorf("%w for table %s", ErrNoTenant, t.TableName)
+	}
+	if conv := t.dbmap.TypeConverter; conv != nil {
+		var err error
+		if tenant, err = conv.ToDb(tenant); err != nil {
+			return nil, err
+		}
+	}
+	return []interface{}{tenant}, nil
+}
+
+// stampTenant sets the tenant field of the row elem to the tenant held by
+// the context of exec, if t is scoped by tenant.
+func (t *TableMap) stampTenant(exec SqlExecutor, elem reflect.Value) error {
+	if t.tenant == nil {
+		return nil
+	}
+	_, ctx := extractExecutorAndContext(exec)
+	tenant, ok := TenantFrom(ctx)
+	if !ok {
+		return fmt.Errorf("%w for table %s", ErrNoTenant, t.TableName)
+	}
+	f := elem.FieldByName(t.tenant.fieldName)
+	v, ok := convertTenant(reflect.ValueOf(tenant), f.Type())
+	if !ok {
+		return fmt.Errorf("gorp: tenant %v of type %T cannot be set on field %s of %s",
+			tenant, tenant, t.tenant.fieldName, t.gotype.Name())
+	}
+	f.Set(v)
+	return nil
+}
+
+// convertTenant converts the tenant v to typ if it can be without
+// changing its value: if it is assignable, of the same kind, or an
+// integer that fits in typ.  Go conversions such as int to string or
+// float to int are refused.
+func convertTenant(v reflect.Value, typ reflect.Type) (reflect.Value, bool) {
+	switch {
+	case v.Type().AssignableTo(typ):
+		return v, true
+	case v.Kind() == typ.Kind():
+		return v.Convert(typ), true
+	}
+	n := reflect.New(typ).Elem()
+	switch {
+	case isIntKind(v.Kind()) && isIntKind(typ.Kind()):
+		if n.OverflowInt(v.Int()) {
+			return reflect.Value{}, false
+		}
+	case isIntKind(v.Kind()) && isUintKind(typ.Kind()):
+		if v.Int() < 0 || n.OverflowUint(uint64(v.Int())) {
+			return reflect.Value{}, false
+		}
+	case isUintKind(v.Kind()) && isIntKind(typ.Kind()):
+		if v.Uint() > 1<<63-1 || n.OverflowInt(int64(v.Uint())) {
+			return reflect.Value{}, false
+		}
+	case isUintKind(v.Kind()) && isUintKind(typ.Kind()):
+		if n.OverflowUint(v.Uint()) {
+			return reflect.Value{}, false
+		}
+	default:
+		return reflect.Value{}, false
+	}
+	return v.Convert(typ), true
+}
+
+func isIntKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUintKind(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+// writeTenantCond writes the condition matching the rows of the tenant,
+// with the bind variable numbered x, if t is scoped by tenant.
+func (t *TableMap) writeTenantCond(s *bytes.Buffer, x int) {
+	if t.tenant == nil {
+		return
+	}
+	s.WriteString(" and ")
+	s.WriteString(t.dbmap.Dialect.QuoteField(t.tenant.ColumnName))
+	s.WriteString("=")
+	s.WriteString(t.dbmap.Dialect.BindVar(x))
+}
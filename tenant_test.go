@@ -0,0 +1,123 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//go:build !integration
+// +build !integration
+
+package gorp_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-gorp/gorp/v3"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type ScopedNote struct {
+	Id       int64
+	TenantId int64
+	Body     string
+	Version  int64
+	Deleted  bool
+}
+
+func TestTenantSqlPostgres(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	dbmap := &gorp.DbMap{Db: db, Dialect: gorp.PostgresDialect{}}
+	notes := dbmap.AddTableWithName(ScopedNote{}, "note").SetKeys(true, "Id")
+	notes.SetVersionCol("Version")
+	notes.SetSoftDeleteCol("Deleted")
+	notes.SetTenantCol("TenantId")
+
+	// the statements are captured instead of being run
+	var query string
+	var args []interface{}
+	dbmap.AddInterceptors(gorp.InterceptorFunc(func(ev *gorp.QueryEvent, next func() error) error {
+		query, args = ev.Query, ev.Args
+		return errors.New("not run")
+	}))
+	exec := dbmap.WithContext(gorp.WithTenant(context.Background(), int64(7)))
+	m := exec.(*gorp.DbMap)
+	note := &ScopedNote{Id: 1, TenantId: 7, Body: "b", Version: 3}
+
+	tests := []struct {
+		name  string
+		run   func() error
+		query string
+		nargs int
+	}{
+		{"Get", func() error { _, err := exec.Get(ScopedNote{}, 1); return err },
+			`select "Id","TenantId","Body","Version","Deleted" from "note" where "Id"=$1 and "Deleted" = $2 and "TenantId"=$3;`, 3},
+		{"Update", func() error { _, err := exec.Update(note); return err },
+			`update "note" set "Body"=$1, "Version"=$2, "Deleted"=$3 where "Id"=$4 and "Version"=$5 and "TenantId"=$6;`, 6},
+		{"Delete", func() error { _, err := exec.Delete(note); return err },
+			`update "note" set "Deleted"=$1, "Version"="Version"+1 where "Id"=$2 and "Version"=$3 and "Deleted" = $4 and "TenantId"=$5;`, 5},
+		{"Restore", func() error { _, err := m.Restore(note); return err },
+			`update "note" set "Deleted"=$1, "Version"="Version"+1 where "Id"=$2 and "Version"=$3 and "Deleted" = $4 and "TenantId"=$5;`, 5},
+		{"From", func() error { _, err := m.From(&ScopedNote{}).Where("Body", "=", "b").Select(); return err },
+			`select "Id","TenantId","Body","Version","Deleted" from "note" where "Body" = $1 and "Deleted" = $2 and "TenantId" = $3;`, 3},
+	}
+	for _, tt := range tests {
+		query, args = "", nil
+		if err := tt.run(); err == nil {
+			t.Errorf("%s: expected the interceptor to fail the call", tt.name)
+		}
+		if query != tt.query || len(args) != tt.nargs {
+			t.Errorf("%s: expected %s with %d arguments, got %s with %v", tt.name, tt.query, tt.nargs, query, args)
+		}
+		if len(args) > 0 && args[len(args)-1] != int64(7) {
+			t.Errorf("%s: expected the tenant as the last argument, got %v", tt.name, args)
+		}
+	}
+}
+
+type StringTenantNote struct {
+	Id       int64
+	TenantId string
+}
+
+func TestTenantStamping(t *testing.T) {
+	dbmap := &gorp.DbMap{Dialect: gorp.PostgresDialect{}}
+	dbmap.AddTableWithName(ScopedNote{}, "note").SetKeys(true, "Id").SetTenantCol("TenantId")
+	dbmap.AddTableWithName(StringTenantNote{}, "string_note").SetKeys(true, "Id").SetTenantCol("TenantId")
+	var args []interface{}
+	dbmap.AddInterceptors(gorp.InterceptorFunc(func(ev *gorp.QueryEvent, next func() error) error {
+		args = ev.Args
+		return errors.New("not run")
+	}))
+	insert := func(tenant interface{}, row interface{}) error {
+		return dbmap.WithContext(gorp.WithTenant(context.Background(), tenant)).Insert(row)
+	}
+
+	// integers are converted to the type of the column if they fit
+	note := &ScopedNote{}
+	insert(7, note)
+	if note.TenantId != 7 || len(args) == 0 || args[0] != int64(7) {
+		t.Errorf("Expected an int tenant to be stamped on an int64 column, got %d and %v", note.TenantId, args)
+	}
+
+	// conversions changing the value are refused
+	for _, tt := range []struct {
+		tenant interface{}
+		row    interface{}
+	}{
+		{65, &StringTenantNote{}},
+		{7.5, &ScopedNote{}},
+		{"7", &ScopedNote{}},
+	} {
+		args = nil
+		err := insert(tt.tenant, tt.row)
+		if err == nil || !strings.Contains(err.Error(), "cannot be set") || args != nil {
+			t.Errorf("Expected tenant %#v to be refused for %T, got %v", tt.tenant, tt.row, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	timePtrType  = reflect.TypeOf(&time.Time{})
+	sqlNullTime  = reflect.TypeOf(sql.NullTime{})
+	gorpNullTime = reflect.TypeOf(NullTime{})
+)
+
+// SetTimestampCols sets the columns holding the time a row was created
+// and last updated, replacing those set with the autocreatetime and
+// autoupdatetime tag options.  Either field may be empty.
+//
+// Insert sets the created field, unless it already holds a time, and the
+// updated field; Update sets the updated field.  The fields must be of
+// type time.Time, *time.Time, sql.NullTime or NullTime.  Times come from
+// DbMap.Now.  Panics if the struct does not contain a field matching a
+// name or the field is of another type.
+//
+// Automatically calls ResetSql() to ensure SQL statements are regenerated.
+func (t *TableMap) SetTimestampCols(created, updated string) *TableMap {
+	for _, col := range t.Columns {
+		col.isCreateTime, col.isUpdateTime = false, false
+	}
+	if created != "" {
+		col := t.ColMap(created)
+		t.checkTimeField(col)
+		col.isCreateTime = true
+	}
+	if updated != "" {
+		col := t.ColMap(updated)
+		t.checkTimeField(col)
+		col.isUpdateTime = true
+	}
+	t.ResetSql()
+	return t
+}
+
+// checkTimeField panics if the field of col can't hold a time set by
+// gorp.
+func (t *TableMap) checkTimeField(col *ColumnMap) {
+	if typ := t.fieldType(col); !isTimeType(typ) {
+		panic(fmt.Sprintf("gorp: field %s of %s must be a time.Time, *time.Time, sql.NullTime or NullTime, got %s",
+			col.fieldName, t.gotype.Name(), typ))
+	}
+}
+
+// fieldType returns the type of the struct field mapped to col, which
+// differs from the column type when a TypeConverter or driver.Valuer is
+// involved.
+func (t *TableMap) fieldType(col *ColumnMap) reflect.Type {
+	f, _ := t.gotype.FieldByName(col.fieldName)
+	return f.Type
+}
+
+func isTimeType(t reflect.Type) bool {
+	switch t {
+	case timeType, timePtrType, sqlNullTime, gorpNullTime:
+		return true
+	}
+	return false
+}
+
+// timeValue returns now as a value of t, which is one of the types
+// accepted by isTimeType.
+func timeValue(t reflect.Type, now time.Time) reflect.Value {
+	switch t {
+	case timePtrType:
+		return reflect.ValueOf(&now)
+	case sqlNullTime:
+		return reflect.ValueOf(sql.NullTime{Time: now, Valid: true})
+	case gorpNullTime:
+		return reflect.ValueOf(NullTime{Time: now, Valid: true})
+	}
+	return reflect.ValueOf(now)
+}
+
+// now returns the current time of the Now clock in UTC, truncated to the
+// precision of the Dialect.
+func (m *DbMap) now() time.Time {
+	now := time.Now
+	if m.Now != nil {
+		now = m.Now
+	}
+	t := now().UTC()
+	if p, ok := m.Dialect.(TimePrecisioner); ok {
+		return t.Truncate(p.TimePrecision())
+	}
+	return t.Round(0)
+}
+
+// setTimestamps sets the timestamp fields of elem before it is inserted,
+// or updated if insert is false.
+func (t *TableMap) setTimestamps(elem reflect.Value, insert bool) {
+	var now time.Time
+	for _, col := range t.Columns {
+		if col.Transient || !(col.isUpdateTime || insert && col.isCreateTime) {
+			continue
+		}
+		f := elem.FieldByName(col.fieldName)
+		if !col.isUpdateTime && !f.IsZero() {
+			continue
+		}
+		if now.IsZero() {
+			now = t.dbmap.now()
+		}
+		f.Set(timeValue(f.Type(), now))
+	}
+}
@@ -7,6 +7,7 @@ package gorp
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"time"
 )
 
@@ -15,10 +16,48 @@ import (
 // of that transaction.  Transactions should be terminated with
 // a call to Commit() or Rollback()
 type Transaction struct {
-	ctx    context.Context
-	dbmap  *DbMap
-	tx     *sql.Tx
+	ctx      context.Context
+	dbmap    *DbMap
+	tx       *sql.Tx
+	unscoped bool
+	opts     sql.TxOptions
+
+	// state is shared by the copies made by WithContext and Unscoped.
+	state *txState
+
+	// parent is the transaction a nested transaction was begun in, and
+	// savepoint the name of the savepoint backing it; see Begin.
+	parent    *Transaction
+	savepoint string
+
+	// method and table are reported to interceptors; see withCall
+	method string
+	table  string
+
+	// uncached holds the Cache keys of the rows written in the
+	// transaction, removed from the Cache on commit.  It is shared by
+	// the copies made by WithContext and Unscoped, and by the nested
+	// transactions.
+	uncached *[]string
+}
+
+// txState is the state of a Transaction that changes once it is begun.
+type txState struct {
 	closed bool
+
+	// savepoints numbers the savepoints of nested transactions; only the
+	// counter of the outermost transaction is used.
+	savepoints int
+
+	// onCommit and onRollback hold the callbacks registered with
+	// OnCommit and OnRollback.
+	onCommit   []func()
+	onRollback []func()
+
+	// open holds the states of the nested transactions begun in this one,
+	// which are committed with it or whose OnRollback callbacks run if it
+	// is rolled back while they are still open.
+	open []*txState
 }
 
 func (t *Transaction) WithContext(ctx context.Context) SqlExecutor {
@@ -28,11 +67,33 @@ func (t *Transaction) WithContext(ctx context.Context) SqlExecutor {
 	return copy
 }
 
+// Options returns the options the transaction was begun with; see
+// DbMap.BeginTx.  They are the zero value for transactions begun with
+// Begin.
+func (t *Transaction) Options() sql.TxOptions {
+	return t.opts
+}
+
 // Insert has the same behavior as DbMap.Insert(), but runs in a transaction.
 func (t *Transaction) Insert(list ...interface{}) error {
 	return insert(t.dbmap, t, list...)
 }
 
+// InsertBatch has the same behavior as DbMap.InsertBatch(), but runs in a transaction.
+func (t *Transaction) InsertBatch(list ...interface{}) error {
+	return insertBatch(t.dbmap, t, list...)
+}
+
+// Upsert has the same behavior as DbMap.Upsert(), but runs in a transaction.
+func (t *Transaction) Upsert(list ...interface{}) error {
+	return upsert(t.dbmap, t, "", nil, list...)
+}
+
+// UpsertColumns has the same behavior as DbMap.UpsertColumns(), but runs in a transaction.
+func (t *Transaction) UpsertColumns(conflict string, filter ColumnFilter, list ...interface{}) error {
+	return upsert(t.dbmap, t, conflict, filter, list...)
+}
+
 // Update had the same behavior as DbMap.Update(), but runs in a transaction.
 func (t *Transaction) Update(list ...interface{}) (int64, error) {
 	return update(t.dbmap, t, nil, list...)
@@ -45,7 +106,7 @@ func (t *Transaction) UpdateColumns(filter ColumnFilter, list ...interface{}) (i
 
 // Delete has the same behavior as DbMap.Delete(), but runs in a transaction.
 func (t *Transaction) Delete(list ...interface{}) (int64, error) {
-	return delete(t.dbmap, t, list...)
+	return deleteRows(t.dbmap, t, false, list...)
 }
 
 // Get has the same behavior as DbMap.Get(), but runs in a transaction.
@@ -62,6 +123,16 @@ func (t *Transaction) Select(i interface{}, query string, args ...interface{}) (
 	return hookedselect(t.dbmap, t, i, query, args...)
 }
 
+// SelectPage has the same behavior as DbMap.SelectPage(), but runs in a transaction.
+func (t *Transaction) SelectPage(i interface{}, query string, page Page, args ...interface{}) ([]interface{}, int64, error) {
+	return SelectPage(t, i, query, page, args...)
+}
+
+// SelectIter has the same behavior as DbMap.SelectIter(), but runs in a transaction.
+func (t *Transaction) SelectIter(query string, args ...interface{}) (*Cursor, error) {
+	return SelectIter(t, query, args...)
+}
+
 // Exec has the same behavior as DbMap.Exec(), but runs in a transaction.
 func (t *Transaction) Exec(query string, args ...interface{}) (sql.Result, error) {
 	if t.dbmap.ExpandSliceArgs {
@@ -138,29 +209,123 @@ func (t *Transaction) SelectOne(holder interface{}, query string, args ...interf
 	return SelectOne(t.dbmap, t, holder, query, args...)
 }
 
-// Commit commits the underlying database transaction.
+// Begin starts a nested transaction, backed by a savepoint of t.  Commit
+// on the nested transaction releases the savepoint, keeping its changes
+// as part of t, and Rollback rolls back to the savepoint, undoing them.
+// Its changes are only made permanent once the outermost transaction
+// commits.
+//
+// Since DbMap and Transaction have the same Begin method, code starting
+// its own transaction can run both on its own and inside a transaction
+// begun by its caller, given either one.  The savepoints are named
+// automatically.
+//
+// A nested transaction is closed, and returns sql.ErrTxDone from Begin,
+// Commit and Rollback, once one of the transactions enclosing it is
+// committed or rolled back.
+func (t *Transaction) Begin() (*Transaction, error) {
+	if t.done() {
+		return nil, sql.ErrTxDone
+	}
+	root := t
+	for root.parent != nil {
+		root = root.parent
+	}
+	root.state.savepoints++
+	name := fmt.Sprintf("gorp_savepoint_%d", root.state.savepoints)
+	if err := t.Savepoint(name); err != nil {
+		return nil, err
+	}
+
+	nested := *t
+	nested.method, nested.table = "", ""
+	nested.state = &txState{}
+	t.state.open = append(t.state.open, nested.state)
+	nested.parent = t
+	nested.savepoint = name
+	return &nested, nil
+}
+
+// done reports whether t, or a transaction enclosing it, was committed or
+// rolled back.
+func (t *Transaction) done() bool {
+	for ; t != nil; t = t.parent {
+		if t.state.closed {
+			return true
+		}
+	}
+	return false
+}
+
+// Commit commits the underlying database transaction, or releases the
+// savepoint of a nested transaction; see Begin.
 func (t *Transaction) Commit() error {
-	if !t.closed {
-		t.closed = true
+	if t.parent != nil {
+		if t.done() {
+			return sql.ErrTxDone
+		}
+		t.state.closed = true
+		if err := t.ReleaseSavepoint(t.savepoint); err != nil {
+			return err
+		}
+		t.state.commitOpen()
+		// the callbacks now depend on the outcome of the parent
+		parent := t.parent.state
+		parent.onCommit = append(parent.onCommit, t.state.onCommit...)
+		parent.onRollback = append(parent.onRollback, t.state.onRollback...)
+		return nil
+	}
+
+	if !t.state.closed {
+		t.state.closed = true
 		if t.dbmap.logger != nil {
 			now := time.Now()
 			defer t.dbmap.trace(now, "commit;")
 		}
-		return t.tx.Commit()
+		ev := &QueryEvent{Op: OpCommit, Context: t.ctx, Query: "commit", Tx: t.tx}
+		if err := t.dbmap.intercept(ev, func(*QueryEvent) error { return t.tx.Commit() }); err != nil {
+			return err
+		}
+		if t.dbmap.Cache != nil {
+			for _, key := range *t.uncached {
+				t.dbmap.invalidate(key)
+			}
+		}
+		t.state.commitOpen()
+		return runCallbacks(t.state.onCommit)
 	}
 
 	return sql.ErrTxDone
 }
 
-// Rollback rolls back the underlying database transaction.
+// Rollback rolls back the underlying database transaction, or rolls back
+// to the savepoint of a nested transaction; see Begin.
 func (t *Transaction) Rollback() error {
-	if !t.closed {
-		t.closed = true
+	if t.parent != nil {
+		if t.done() {
+			return sql.ErrTxDone
+		}
+		t.state.closed = true
+		if err := t.RollbackToSavepoint(t.savepoint); err != nil {
+			return err
+		}
+		if err := t.ReleaseSavepoint(t.savepoint); err != nil {
+			return err
+		}
+		return runCallbacks(t.state.rollbackCallbacks())
+	}
+
+	if !t.state.closed {
+		t.state.closed = true
 		if t.dbmap.logger != nil {
 			now := time.Now()
 			defer t.dbmap.trace(now, "rollback;")
 		}
-		return t.tx.Rollback()
+		ev := &QueryEvent{Op: OpRollback, Context: t.ctx, Query: "rollback", Tx: t.tx}
+		if err := t.dbmap.intercept(ev, func(*QueryEvent) error { return t.tx.Rollback() }); err != nil {
+			return err
+		}
+		return runCallbacks(t.state.rollbackCallbacks())
 	}
 
 	return sql.ErrTxDone
@@ -0,0 +1,148 @@
+// Copyright 2012 James Cooper. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gorp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OnCommit registers fn to be called once the transaction is committed,
+// such as to publish an event or invalidate a cache only when the changes
+// it made are visible.  Callbacks are called in the order they were
+// registered, after Commit succeeds; they are not called if the commit
+// fails.
+//
+// The callbacks of a nested transaction (see Begin) are handed to the
+// enclosing transaction when it is committed, and are called once the
+// outermost transaction is committed.  They are discarded if the nested
+// transaction, or one enclosing it, is rolled back.  Nested transactions
+// still open when a transaction is committed are committed with it, and
+// their callbacks are called after its own.
+func (t *Transaction) OnCommit(fn func()) {
+	t.state.onCommit = append(t.state.onCommit, fn)
+}
+
+// OnRollback registers fn to be called once the transaction is rolled
+// back, after Rollback succeeds.  Callbacks are called in the order they
+// were registered.
+//
+// The callbacks of a nested transaction are called when it is rolled back
+// to its savepoint, or when an enclosing transaction is rolled back while
+// it is still open or once it is committed.  The callbacks of nested
+// transactions still open are called after those of the transaction
+// rolled back.
+func (t *Transaction) OnRollback(fn func()) {
+	t.state.onRollback = append(t.state.onRollback, fn)
+}
+
+// rollbackCallbacks returns the OnRollback callbacks to call when the
+// transaction of s is rolled back: its own, followed by those of its
+// nested transactions still open.
+func (s *txState) rollbackCallbacks() []func() {
+	fns := append([]func(){}, s.onRollback...)
+	for _, nested := range s.open {
+		if !nested.closed {
+			fns = append(fns, nested.rollbackCallbacks()...)
+		}
+	}
+	return fns
+}
+
+// commitOpen closes the nested transactions of s still open, whose changes
+// were committed with those of s, and appends their callbacks to those of
+// s.
+func (s *txState) commitOpen() {
+	for _, nested := range s.open {
+		if !nested.closed {
+			nested.closed = true
+			nested.commitOpen()
+			s.onCommit = append(s.onCommit, nested.onCommit...)
+			s.onRollback = append(s.onRollback, nested.onRollback...)
+		}
+	}
+}
+
+// OnCommit registers fn to be called once the changes made with exec are
+// committed, so that hooks, which are given a SqlExecutor, can defer work
+// to the end of the transaction they run in.  If exec is a Transaction,
+// fn is registered with Transaction.OnCommit; otherwise the changes are
+// already committed and fn is called right away, returning a
+// *CallbackError if it panics.
+//
+// Example:
+//
+//	func (i *Invoice) PostInsert(s gorp.SqlExecutor) error {
+//		return gorp.OnCommit(s, func() { notify("invoice created", i.Id) })
+//	}
+func OnCommit(exec SqlExecutor, fn func()) error {
+	if t, ok := exec.(*Transaction); ok {
+		t.OnCommit(fn)
+		return nil
+	}
+	return runCallbacks([]func(){fn})
+}
+
+// OnRollback registers fn to be called if the changes made with exec are
+// rolled back.  If exec is a Transaction, fn is registered with
+// Transaction.OnRollback; otherwise the changes cannot be rolled back and
+// fn is never called.
+func OnRollback(exec SqlExecutor, fn func()) {
+	if t, ok := exec.(*Transaction); ok {
+		t.OnRollback(fn)
+	}
+}
+
+// CallbackError is returned by Commit and Rollback when callbacks
+// registered with OnCommit or OnRollback panicked.  The transaction was
+// committed or rolled back all the same, and the other callbacks were
+// called.
+type CallbackError struct {
+	// Errors holds an error for each callback that panicked, in the
+	// order they were called.
+	Errors []error
+}
+
+func (e *CallbackError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "gorp: transaction callbacks failed: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors of the callbacks.
+func (e *CallbackError) Unwrap() []error {
+	return e.Errors
+}
+
+// runCallbacks calls each of fns, recovering from panics, and returns a
+// *CallbackError if any panicked.
+func runCallbacks(fns []func()) error {
+	var errs []error
+	for _, fn := range fns {
+		if err := runCallback(fn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return &CallbackError{Errors: errs}
+	}
+	return nil
+}
+
+func runCallback(fn func()) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			if e, ok := p.(error); ok {
+				err = fmt.Errorf("gorp: callback panicked: %w", e)
+			} else {
+				err = fmt.Errorf("gorp: callback panicked: %v", p)
+			}
+		}
+	}()
+	fn()
+	return nil
+}